@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminToken guards internal endpoints. When empty they are disabled.
+var adminToken = ""
+
+// adminMiddleware requires a matching X-Admin-Token header.
+func adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminToken == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin endpoints are disabled"})
+			return
+		}
+		token := c.GetHeader("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// cancelOrderPayments cancels every pending payment of an order, e.g. after
+// the order was cancelled upstream. Payments in other states are skipped.
+func cancelOrderPayments(c *gin.Context) {
+	orderID := c.Param("order_id")
+	if !isValidOrderID(orderID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	now := clock()
+	cancelled := 0
+	paymentsMutex.Lock()
+	for _, payment := range payments.ListByOrder(orderID) {
+		if payment.Status == "pending" {
+			payment.Status = "cancelled"
+			payment.CancelledAt = &now
+			payment.CancelReason = "order cancelled"
+			persistPayment(payment)
+			releaseDailyTotal(payment, float64(payment.Amount))
+			cancelled++
+		}
+	}
+	paymentsMutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"order_id": orderID, "cancelled": cancelled})
+}
+
+// resetPayments wipes payment state between end-to-end test cases: every
+// payment along with the order index, the order-validation cache, the
+// Idempotency-Keys and the daily order totals.
+func resetPayments(c *gin.Context) {
+	paymentsMutex.Lock()
+	removed, err := payments.Clear()
+	paymentsMutex.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear payments"})
+		return
+	}
+	cached := clearOrderCache()
+
+	idempotencyMutex.Lock()
+	idempotencyKeys = make(map[string]*idempotencyEntry)
+	idempotencyMutex.Unlock()
+
+	dailyTotalsMutex.Lock()
+	dailyTotals = make(map[string]float64)
+	dailyTotalsMutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"removed": removed, "cache_entries_removed": cached})
+}
+
+// redact hides a secret while still showing whether it is configured.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
+
+// redactURL drops the parts of a URL that may carry credentials - the
+// userinfo, query and fragment - keeping where it points.
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return redact(raw)
+	}
+	parsed.User = nil
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+	return parsed.String()
+}
+
+// getEffectiveConfig reports the configuration actually in effect after
+// environment overrides, with secrets masked.
+func getEffectiveConfig(c *gin.Context) {
+	suites := make([]string, 0, len(tlsCipherSuites))
+	for _, id := range tlsCipherSuites {
+		suites = append(suites, tls.CipherSuiteName(id))
+	}
+	curves := make([]string, 0, len(tlsCurves))
+	for _, curve := range tlsCurves {
+		curves = append(curves, curve.String())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"test_mode":              testMode,
+		"log_level":              logLevel.Level().String(),
+		"admin_token":            redact(adminToken),
+		"allowed_order_hosts":    allowedHosts,
+		"order_service_url":      redactURL(orderServiceURL),
+		"grpc_port":              grpcPort,
+		"order_client_timeout":   httpClient.Timeout.String(),
+		"readiness_order_host":   readinessTarget(),
+		"readiness_timeout":      readinessTimeout.String(),
+		"readiness_cache_ttl":    readinessCacheTTL.String(),
+		"order_cache_expiry":     cacheExpiry.String(),
+		"order_cache_max":        orderCacheMaxEntries,
+		"validation_timeout":     validationTotalTimeout.String(),
+		"validation_attempts":    validationMaxAttempts,
+		"retry_base_delay":       validationRetryBaseDelay.String(),
+		"retry_max_delay":        validationRetryMaxDelay.String(),
+		"retry_budget":           validationRetryBudget,
+		"retry_budget_refill":    validationRetryBudgetRefill,
+		"order_batch_window":     orderBatchWindow.String(),
+		"order_batch_max":        orderBatchMax,
+		"breaker_threshold":      orderBreaker.threshold,
+		"breaker_cooldown":       orderBreaker.cooldown.String(),
+		"gateway":                fmt.Sprintf("%T", gateway),
+		"failure_threshold":      paymentFailureThreshold,
+		"method_failure_rates":   methodFailureRates,
+		"method_failure_seed":    methodFailureSeed,
+		"gateway_timeout":        gatewayTimeout.String(),
+		"gateway_timeout_policy": gatewayTimeoutPolicy,
+		"process_delay":          processingDelay.String(),
+		"async_process_delay":    asyncProcessDelay.String(),
+		"max_payment_amount":     maxPaymentAmount,
+		"payment_id_generator":   idGeneratorKind,
+		"payment_id_seed":        idGeneratorSeed,
+		"payment_methods":        paymentMethods,
+		"metadata_max_keys":      maxMetadataKeys,
+		"metadata_max_bytes":     maxMetadataBytes,
+		"method_amount_limits":   methodAmountLimits,
+		"payment_validators":     validatorNames(),
+		"otlp_endpoint":          redactURL(otlpEndpoint),
+		"webhook_url":            redactURL(webhookURL),
+		"webhook_secret":         redact(webhookSecret),
+		"webhook_tolerance":      webhookTolerance.String(),
+		"match_order_total":      matchOrderTotal,
+		"amount_tolerance_abs":   amountToleranceAbs,
+		"amount_tolerance_rel":   amountToleranceRel,
+		"daily_order_cap":        dailyOrderCap,
+		"bulk_max_items":         maxBulkItems,
+		"max_stored_payments":    maxStoredPayments,
+		"max_heap_mb":            maxHeapMB,
+		"payment_store":          paymentStoreKind,
+		"restore_conflicts":      restoreConflictPolicy,
+		"list_max_age":           listMaxAge.String(),
+		"idempotency_ttl":        idempotencyTTL.String(),
+		"shutdown_timeout":       shutdownTimeout.String(),
+		"amount_as_string":       amountsAsStrings,
+		"currency_formats":       currencyFormats,
+		"sanitization_policy":    sanitizationPolicy,
+		"accepted_content_types": acceptedContentTypes,
+		"fault_latency":          faultLatency.String(),
+		"fault_error_rate":       faultErrorRate,
+		"fault_seed":             faultSeed,
+		"rate_limit_rps":         rateLimitRPS,
+		"rate_limit_burst":       rateLimitBurst,
+		"trusted_proxies":        trustedProxies,
+		"pending_payment_ttl":    pendingPaymentTTL.String(),
+		"expiry_sweep_interval":  expirySweepInterval.String(),
+		"csrf_enforce":           csrfEnforce,
+		"csrf_token_ttl":         csrfTokenTTL.String(),
+		"csrf_max_tokens":        csrfMaxTokens,
+		"nonce_enforce":          nonceEnforce,
+		"nonce_ttl":              nonceTTL.String(),
+		"tls_enabled":            tlsEnabled(),
+		"tls_cipher_suites":      suites,
+		"tls_curves":             curves,
+	})
+}
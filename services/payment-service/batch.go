@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+var batchIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func isValidBatchID(batchID string) bool {
+	return batchIDPattern.MatchString(batchID)
+}
+
+// batchStatus summarises a batch: the shared status when every payment
+// agrees, "in_progress" while any is still pending, otherwise "partial".
+func batchStatus(counts map[string]int, total int) string {
+	for status, count := range counts {
+		if count == total {
+			return status
+		}
+	}
+	if counts["pending"] > 0 || counts["deferred"] > 0 {
+		return "in_progress"
+	}
+	return "partial"
+}
+
+// getPaymentBatch returns all payments stamped with a batch ID together
+// with per-status counts for reconciliation.
+func getPaymentBatch(c *gin.Context) {
+	batchID := c.Param("batch_id")
+	if !isValidBatchID(batchID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch ID"})
+		return
+	}
+
+	// Copied under the lock; settlement may be updating the originals
+	batch := make([]Payment, 0)
+	counts := make(map[string]int)
+	paymentsMutex.RLock()
+	for _, payment := range payments.List() {
+		if payment.BatchID == batchID {
+			batch = append(batch, *payment)
+			counts[payment.Status]++
+		}
+	}
+	paymentsMutex.RUnlock()
+
+	if len(batch) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Batch not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"batch_id":      batchID,
+		"status":        batchStatus(counts, len(batch)),
+		"total":         len(batch),
+		"status_counts": counts,
+		"payments":      batch,
+	})
+}
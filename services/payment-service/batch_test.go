@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// GET /payments/batch/:batch_id answers with copies, so encoding them
+// doesn't race with background settlement (run with -race).
+func TestPaymentBatchWhileSettling(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &asyncProcessDelay, 0)
+
+	for i := 0; i < 10; i++ {
+		body := fmt.Sprintf(`{"order_id":%q,"amount":10,"method":"pix","batch_id":"nightly"}`, uuid.NewString())
+		w := doRequest(t, r, http.MethodPost, "/payments", body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create = %d %s, want 201", w.Code, w.Body.String())
+		}
+		payment := decodeJSON[Payment](t, w)
+		doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process?async=true", "", "If-Match", "*")
+		if w := doRequest(t, r, http.MethodGet, "/payments/batch/nightly", ""); w.Code != http.StatusOK {
+			t.Fatalf("GET batch = %d %s", w.Code, w.Body.String())
+		}
+	}
+	asyncProcessing.Wait()
+
+	batch := decodeJSON[struct {
+		Status string `json:"status"`
+		Total  int    `json:"total"`
+	}](t, doRequest(t, r, http.MethodGet, "/payments/batch/nightly", ""))
+	if batch.Status != "completed" || batch.Total != 10 {
+		t.Fatalf("batch = %+v, want 10 completed payments", batch)
+	}
+}
+
+func TestPaymentBatchStatus(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	create := func(batchID string, amount float64) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"order_id":%q,"amount":%v,"method":"pix","batch_id":%q}`, uuid.NewString(), amount, batchID)
+		return doRequest(t, r, http.MethodPost, "/payments", body)
+	}
+	batchStatus := func() string {
+		w := doRequest(t, r, http.MethodGet, "/payments/batch/april", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET batch = %d %s, want 200", w.Code, w.Body.String())
+		}
+		return decodeJSON[struct {
+			Status string `json:"status"`
+		}](t, w).Status
+	}
+
+	small := decodeJSON[Payment](t, create("april", 10))
+	large := decodeJSON[Payment](t, create("april", 5000))
+	if got := batchStatus(); got != "pending" {
+		t.Fatalf("new batch status = %s, want pending", got)
+	}
+	mustProcessPayment(t, r, small.ID)
+	if got := batchStatus(); got != "in_progress" {
+		t.Fatalf("half-processed batch status = %s, want in_progress", got)
+	}
+	doRequest(t, r, http.MethodPost, "/payments/"+large.ID+"/process", "", "If-Match", "*")
+	if got := batchStatus(); got != "partial" {
+		t.Fatalf("batch with a failure status = %s, want partial", got)
+	}
+
+	if w := create("no spaces", 10); w.Code != http.StatusBadRequest {
+		t.Fatalf("create with a malformed batch ID = %d, want 400", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodGet, "/payments/batch/may", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET unknown batch = %d, want 404", w.Code)
+	}
+}
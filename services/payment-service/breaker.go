@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+var errBreakerOpen = errors.New("order-service circuit breaker is open")
+
+// circuitBreaker stops calls to a failing dependency. After threshold
+// consecutive failures it opens and rejects calls for cooldown, then lets a
+// single trial call through (half-open): success closes it again, failure
+// reopens it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	trialing  bool
+}
+
+// orderBreaker guards calls to the order-service
+// (BREAKER_FAILURE_THRESHOLD, BREAKER_COOLDOWN).
+var orderBreaker = &circuitBreaker{threshold: 5, cooldown: 10 * time.Second, state: breakerClosed}
+
+// allow reports whether a call may go ahead. In half-open state only one
+// trial call is allowed at a time.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if clock().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.trialing = true
+		return true
+	case breakerHalfOpen:
+		if b.trialing {
+			return false
+		}
+		b.trialing = true
+		return true
+	}
+	return true
+}
+
+// success records a call that got an answer and closes the breaker.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.trialing = false
+	b.setState(breakerClosed)
+}
+
+// failure records a failed call, opening the breaker once the threshold is
+// reached or when the half-open trial fails.
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trialing = false
+	if b.state == breakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.openedAt = clock()
+		b.setState(breakerOpen)
+	}
+}
+
+// abandon records a call the caller gave up on. It says nothing about the
+// dependency, but frees the half-open trial slot for the next call.
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialing = false
+}
+
+// State returns "closed", "open" or "half_open".
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// setState changes state and mirrors it to the metrics gauge. The caller
+// must hold b.mu.
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	switch state {
+	case breakerClosed:
+		orderBreakerState.Set(0)
+	case breakerHalfOpen:
+		orderBreakerState.Set(1)
+	case breakerOpen:
+		orderBreakerState.Set(2)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	r := newTestRouter(t)
+	advance := useFakeClock(t)
+	setVar(t, &validationMaxAttempts, 1)
+	setVar(t, &orderBreaker.threshold, 2)
+	var calls atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			http.Error(w, `{"error":"down"}`, http.StatusInternalServerError)
+			return
+		}
+		ordersFound(w, req)
+	})
+	create := func() int {
+		return doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix")).Code
+	}
+
+	create()
+	create()
+	if state := orderBreaker.State(); state != breakerOpen {
+		t.Fatalf("breaker after %d failures is %s, want open", 2, state)
+	}
+	if code := create(); code != http.StatusServiceUnavailable || calls.Load() != 2 {
+		t.Fatalf("create with the breaker open = %d after %d calls, want 503 without calling", code, calls.Load())
+	}
+
+	advance(orderBreaker.cooldown)
+	if code := create(); code != http.StatusServiceUnavailable || orderBreaker.State() != breakerOpen {
+		t.Fatalf("failed trial = %d, breaker %s; want 503 and the breaker open again", code, orderBreaker.State())
+	}
+	advance(orderBreaker.cooldown)
+	failing.Store(false)
+	if code := create(); code != http.StatusCreated || orderBreaker.State() != breakerClosed {
+		t.Fatalf("successful trial = %d, breaker %s; want 201 and the breaker closed", code, orderBreaker.State())
+	}
+}
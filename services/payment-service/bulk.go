@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Most payments one POST /payments/batch call may create (BULK_MAX_ITEMS)
+var maxBulkItems = 100
+
+// bulkResult reports what happened to one item of a bulk create, in
+// request order. Status is the code the item would have got from
+// POST /payments.
+type bulkResult struct {
+	Index     int      `json:"index"`
+	Status    int      `json:"status"`
+	Payment   *Payment `json:"payment,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Validator string   `json:"validator,omitempty"`
+}
+
+func (r *bulkResult) reject(status int, body gin.H) {
+	r.Status = status
+	r.Error, _ = body["error"].(string)
+	r.Validator, _ = body["validator"].(string)
+}
+
+// createPaymentsBulk creates up to maxBulkItems payments in one call. Each
+// item is validated as POST /payments would and fails on its own; every
+// order is validated once however many items reference it, and the valid
+// payments are stored under a single acquisition of the payments lock.
+// With ?stream=true each item is stored as soon as it is validated and its
+// result written at once as a line of NDJSON, in request order.
+func createPaymentsBulk(c *gin.Context) {
+	// Decoded without binding so a missing field fails only its own item
+	var reqs []CreatePaymentRequest
+	raw, err := c.GetRawData()
+	if err == nil {
+		err = json.Unmarshal(raw, &reqs)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Body must be a JSON array of payments: " + err.Error()})
+		return
+	}
+	if len(reqs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Body must be a non-empty JSON array of payments"})
+		return
+	}
+	if len(reqs) > maxBulkItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d payments per batch", maxBulkItems)})
+		return
+	}
+
+	batch := &bulkBatch{
+		ctx:            c.Request.Context(),
+		skipValidation: testMode && c.GetHeader("X-Test-Skip-Validation") == "true",
+		validations:    make(map[string]validationTrace),
+	}
+	if !batch.skipValidation {
+		orderIDs := make([]string, len(reqs))
+		for i, req := range reqs {
+			orderIDs[i] = req.OrderID
+		}
+		prefetchOrderValidations(batch.ctx, orderIDs)
+	}
+	if c.Query("stream") == "true" {
+		streamPaymentsBulk(c, batch, reqs)
+		return
+	}
+
+	results := make([]bulkResult, len(reqs))
+	pending := make([]*Payment, len(reqs))
+	reservations := make([]*dailyReservation, len(reqs))
+	for i := range reqs {
+		results[i].Index = i
+		pending[i], reservations[i] = batch.prepare(&reqs[i], &results[i])
+	}
+
+	// Snapshots for the webhooks before other requests can see the payments
+	created := make([]Payment, 0, len(reqs))
+	paymentsMutex.Lock()
+	for i, payment := range pending {
+		if payment == nil {
+			continue
+		}
+		snapshot := *payment
+		if err := payments.Save(payment); err != nil {
+			reservations[i].release()
+			results[i].reject(http.StatusInternalServerError, gin.H{"error": "Failed to store payment"})
+			continue
+		}
+		results[i].Status = http.StatusCreated
+		results[i].Payment = &snapshot
+		created = append(created, snapshot)
+	}
+	paymentsMutex.Unlock()
+
+	for _, payment := range created {
+		paymentsCreated.Inc()
+		notifyPaymentEvent(eventPaymentCreated, payment)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"created": len(created),
+		"failed":  len(reqs) - len(created),
+		"results": results,
+	})
+}
+
+// streamPaymentsBulk is createPaymentsBulk with ?stream=true: each item is
+// validated, stored and reported before the next one is looked at, so a
+// large batch gives feedback as it goes instead of all at the end.
+func streamPaymentsBulk(c *gin.Context, batch *bulkBatch, reqs []CreatePaymentRequest) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	for i := range reqs {
+		result := bulkResult{Index: i}
+		if payment, reservation := batch.prepare(&reqs[i], &result); payment != nil {
+			snapshot := *payment
+			paymentsMutex.Lock()
+			err := payments.Save(payment)
+			paymentsMutex.Unlock()
+			if err != nil {
+				reservation.release()
+				result.reject(http.StatusInternalServerError, gin.H{"error": "Failed to store payment"})
+			} else {
+				result.Status = http.StatusCreated
+				result.Payment = &snapshot
+				paymentsCreated.Inc()
+				notifyPaymentEvent(eventPaymentCreated, snapshot)
+			}
+		}
+		if err := encoder.Encode(result); err != nil {
+			// The client went away; the items not reached yet are not created
+			logger.Warn("bulk stream aborted", "index", i, "error", err.Error())
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// bulkBatch is the state shared by the items of one bulk create.
+type bulkBatch struct {
+	ctx            context.Context
+	skipValidation bool
+	// Order validations so far, so each order is looked up once
+	validations map[string]validationTrace
+}
+
+// prepare validates one item as POST /payments would. It returns the
+// payment to store along with its daily-total reservation, or nil after
+// recording the rejection in result.
+func (b *bulkBatch) prepare(req *CreatePaymentRequest, result *bulkResult) (*Payment, *dailyReservation) {
+	if req.Currency == "" {
+		req.Currency = defaultCurrency
+	}
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		result.reject(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil
+	}
+	if body := validateCreateRequest(req); body != nil {
+		result.reject(http.StatusBadRequest, body)
+		return nil, nil
+	}
+
+	if !b.skipValidation {
+		validation, seen := b.validations[req.OrderID]
+		if !seen {
+			validation = traceOrderValidation(b.ctx, req.OrderID)
+			b.validations[req.OrderID] = validation
+		}
+		if !validation.Valid {
+			if validation.Transient {
+				result.reject(http.StatusServiceUnavailable, gin.H{"error": "Order service temporarily unavailable"})
+			} else {
+				result.reject(http.StatusBadRequest, gin.H{"error": "Order not found or validation failed"})
+			}
+			return nil, nil
+		}
+	}
+
+	if err := checkOrderTotal(req.OrderID, float64(req.Amount)); err != nil {
+		result.reject(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil
+	}
+	reservation, ok := reserveDailyTotal(req.OrderID, float64(req.Amount))
+	if !ok {
+		result.reject(http.StatusConflict, gin.H{"error": "Payment would exceed the daily total for this order"})
+		return nil, nil
+	}
+	return newPayment(*req), reservation
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBulkCreateMixedBatch(t *testing.T) {
+	r := newTestRouter(t)
+	missingOrder := uuid.NewString()
+	var mu sync.Mutex
+	lookups := make(map[string]int)
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		lookups[path.Base(req.URL.Path)]++
+		mu.Unlock()
+		if path.Base(req.URL.Path) == missingOrder {
+			ordersMissing(w, req)
+			return
+		}
+		ordersFound(w, req)
+	})
+	sharedOrder := uuid.NewString()
+
+	body := "[" + strings.Join([]string{
+		createPaymentBody(sharedOrder, 10, "pix"),
+		fmt.Sprintf(`{"order_id":%q,"method":"pix"}`, uuid.NewString()),
+		createPaymentBody(uuid.NewString(), 10, "banana"),
+		createPaymentBody(missingOrder, 10, "pix"),
+		createPaymentBody(sharedOrder, 20, "boleto"),
+	}, ",") + "]"
+	w := doRequest(t, r, http.MethodPost, "/payments/batch", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bulk create = %d %s, want 200", w.Code, w.Body.String())
+	}
+	result := decodeJSON[struct {
+		Created int          `json:"created"`
+		Failed  int          `json:"failed"`
+		Results []bulkResult `json:"results"`
+	}](t, w)
+
+	want := []int{http.StatusCreated, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest, http.StatusCreated}
+	if result.Created != 2 || result.Failed != 3 || len(result.Results) != len(want) {
+		t.Fatalf("bulk create = %s, want 2 created and 3 failed", w.Body.String())
+	}
+	for i, item := range result.Results {
+		if item.Index != i || item.Status != want[i] {
+			t.Errorf("item %d = index %d status %d, want status %d", i, item.Index, item.Status, want[i])
+		}
+		if (item.Status == http.StatusCreated) != (item.Payment != nil) || (item.Status != http.StatusCreated) != (item.Error != "") {
+			t.Errorf("item %d = %+v, want a payment when created and an error otherwise", i, item)
+		}
+	}
+	if created := result.Results[4].Payment; created == nil || created.OrderID != sharedOrder {
+		t.Fatalf("second payment for the shared order = %+v", created)
+	} else if w := doRequest(t, r, http.MethodGet, "/payments/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("GET a bulk-created payment = %d, want 200", w.Code)
+	}
+	if lookups[sharedOrder] != 1 {
+		t.Errorf("shared order validated %d times, want once", lookups[sharedOrder])
+	}
+}
+
+func TestBulkCreateSizeLimit(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &maxBulkItems, 2)
+
+	item := createPaymentBody(uuid.NewString(), 10, "pix")
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"empty array", "[]", http.StatusBadRequest},
+		{"at the limit", "[" + item + "," + item + "]", http.StatusOK},
+		{"over the limit", "[" + item + "," + item + "," + item + "]", http.StatusBadRequest},
+		{"not an array", item, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := doRequest(t, r, http.MethodPost, "/payments/batch", tt.body); w.Code != tt.want {
+			t.Errorf("bulk create %s = %d %s, want %d", tt.name, w.Code, w.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestBulkCreateStream(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	orderIDs := []string{uuid.NewString(), uuid.NewString(), uuid.NewString()}
+	body := "[" + strings.Join([]string{
+		createPaymentBody(orderIDs[0], 10, "pix"),
+		createPaymentBody(orderIDs[1], 10, "banana"),
+		createPaymentBody(orderIDs[2], 30, "boleto"),
+	}, ",") + "]"
+
+	w := doRequest(t, r, http.MethodPost, "/payments/batch?stream=true", body)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("streamed bulk create = %d (%s), want 200 NDJSON", w.Code, w.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) != len(orderIDs) {
+		t.Fatalf("stream = %q, want a line per item", w.Body.String())
+	}
+	want := []int{http.StatusCreated, http.StatusBadRequest, http.StatusCreated}
+	for i, line := range lines {
+		var item bulkResult
+		if err := json.Unmarshal([]byte(line), &item); err != nil {
+			t.Fatalf("line %d %q: %v", i, line, err)
+		}
+		if item.Index != i || item.Status != want[i] {
+			t.Errorf("line %d = index %d status %d, want status %d", i, item.Index, item.Status, want[i])
+		}
+		if item.Status == http.StatusCreated && (item.Payment == nil || item.Payment.OrderID != orderIDs[i]) {
+			t.Errorf("line %d = %s, want the payment for order %s", i, line, orderIDs[i])
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+import "time"
+
+// clock is the service's source of the current time. Tests replace it to
+// simulate the passage of time.
+var clock = time.Now
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// anyVersion is the expected version for If-Match: * or, where If-Match is
+// optional, no header at all.
+const anyVersion = -1
+
+var errVersionMismatch = errors.New("payment was modified; If-Match does not match its current version")
+
+// expectedVersion reads the payment version a mutation expects from
+// If-Match, accepting 3, "3", W/"3" or *. It answers 400 itself and reports
+// false when the request must stop.
+func expectedVersion(c *gin.Context) (int, bool) {
+	raw := strings.TrimSpace(c.GetHeader("If-Match"))
+	if raw == "" || raw == "*" {
+		return anyVersion, true
+	}
+	raw = strings.Trim(strings.TrimPrefix(raw, "W/"), `"`)
+	version, err := strconv.Atoi(raw)
+	if err != nil || version < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "If-Match must carry a payment version such as \"3\""})
+		return 0, false
+	}
+	return version, true
+}
+
+// requiredVersion is expectedVersion for the mutations that must say which
+// version they expect - process, refund and delete - answering 428 when
+// If-Match is missing. A client that really means any version sends *.
+func requiredVersion(c *gin.Context) (int, bool) {
+	if strings.TrimSpace(c.GetHeader("If-Match")) == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header with the payment version is required"})
+		return 0, false
+	}
+	return expectedVersion(c)
+}
+
+// versionMatches reports whether a payment is still at the version the
+// client expects. Caller must hold paymentsMutex.
+func versionMatches(payment *Payment, expected int) bool {
+	return expected == anyVersion || payment.Version == expected
+}
+
+// paymentETag is the weak entity tag of a payment at a version. It is the
+// same W/"3" form If-Match accepts.
+func paymentETag(version int) string {
+	return fmt.Sprintf(`W/"%d"`, version)
+}
+
+// notModified reports whether the request's If-None-Match already names
+// the payment's current version, comparing weakly as RFC 9110 requires.
+func notModified(c *gin.Context, version int) bool {
+	raw := c.GetHeader("If-None-Match")
+	if raw == "" {
+		return false
+	}
+	current := strings.TrimPrefix(paymentETag(version), "W/")
+	for _, tag := range strings.Split(raw, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed answers 412 with the payment's current version so the
+// client can re-read and retry.
+func preconditionFailed(c *gin.Context, current int) {
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": errVersionMismatch.Error(), "version": current})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Two updates made against the same version: one wins, the other is told
+// the payment moved on.
+func TestConcurrentUpdatesWithIfMatch(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	version := strconv.Itoa(payment.Version)
+
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			codes <- doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process", "", "If-Match", `"`+version+`"`).Code
+		}()
+	}
+	got := map[int]int{<-codes: 1}
+	got[<-codes]++
+	if got[http.StatusOK] != 1 || got[http.StatusPreconditionFailed] != 1 {
+		t.Fatalf("concurrent processing at version %s = %v, want one 200 and one 412", version, got)
+	}
+
+	w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/refund", "", "If-Match", version)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("refund at a stale version = %d %s, want 412", w.Code, w.Body.String())
+	}
+	current := decodeJSON[struct {
+		Version int `json:"version"`
+	}](t, w).Version
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/refund", "", "If-Match", paymentETag(current)); w.Code != http.StatusOK {
+		t.Fatalf("refund at the current version = %d %s, want 200", w.Code, w.Body.String())
+	}
+}
+
+func TestIfMatchHeader(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	target := "/payments/" + payment.ID
+
+	if w := doRequest(t, r, http.MethodDelete, target, "", "If-Match", "three"); w.Code != http.StatusBadRequest {
+		t.Fatalf("delete with a malformed If-Match = %d, want 400", w.Code)
+	}
+	for _, mutation := range []struct{ method, target string }{
+		{http.MethodPost, target + "/process"},
+		{http.MethodPost, target + "/refund"},
+		{http.MethodDelete, target},
+	} {
+		if w := doRequest(t, r, mutation.method, mutation.target, ""); w.Code != http.StatusPreconditionRequired {
+			t.Fatalf("%s %s without If-Match = %d, want 428", mutation.method, mutation.target, w.Code)
+		}
+	}
+	if w := doRequest(t, r, http.MethodPost, target+"/cancel", `{"reason":"duplicate"}`); w.Code != http.StatusOK {
+		t.Fatalf("cancel without If-Match = %d %s, want it optional there", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodDelete, target, "", "If-Match", "*"); w.Code >= 400 {
+		t.Fatalf("delete with If-Match * = %d %s, want it accepted", w.Code, w.Body.String())
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	target := "/payments/" + payment.ID
+
+	w := doRequest(t, r, http.MethodGet, target, "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag != paymentETag(payment.Version) {
+		t.Fatalf("GET = %d with ETag %q, want 200 with %q", w.Code, etag, paymentETag(payment.Version))
+	}
+	for _, ifNoneMatch := range []string{etag, strings.TrimPrefix(etag, "W/"), `"999", ` + etag, "*"} {
+		w := doRequest(t, r, http.MethodGet, target, "", "If-None-Match", ifNoneMatch)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Fatalf("GET with If-None-Match %s = %d %q, want an empty 304 with the ETag", ifNoneMatch, w.Code, w.Body.String())
+		}
+	}
+
+	mustProcessPayment(t, r, payment.ID)
+	w = doRequest(t, r, http.MethodGet, target, "", "If-None-Match", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("GET after an update = %d with ETag %q, want 200 with a new ETag", w.Code, w.Header().Get("ETag"))
+	}
+	if got := decodeJSON[Payment](t, w); w.Header().Get("ETag") != paymentETag(got.Version) {
+		t.Fatalf("ETag %q does not match version %d", w.Header().Get("ETag"), got.Version)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// testMode enables X-Test-* override headers; they are ignored otherwise.
+var testMode = false
+
+// loadConfig applies environment overrides to the package-level defaults.
+// It is called once at startup before the router is built.
+func loadConfig() {
+	testMode = os.Getenv("PAYMENT_TEST_MODE") == "true"
+	if raw := os.Getenv("LOG_LEVEL"); raw != "" {
+		if err := logLevel.UnmarshalText([]byte(raw)); err != nil {
+			warnIgnoredEnv("LOG_LEVEL", raw, err)
+		}
+	}
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	amountsAsStrings = os.Getenv("AMOUNT_AS_STRING") == "true"
+	if raw := os.Getenv("CURRENCY_SYMBOLS"); raw != "" {
+		currencyFormats = parseCurrencyFormats(raw)
+	}
+	if raw := os.Getenv("PAYMENT_METHOD_LIMITS"); raw != "" {
+		limits := make(map[string]amountRange)
+		if err := json.Unmarshal([]byte(raw), &limits); err != nil {
+			warnIgnoredEnv("PAYMENT_METHOD_LIMITS", raw, err)
+		} else {
+			methodAmountLimits = limits
+		}
+	}
+	validatorAmountRange.Min = getEnvFloat("VALIDATOR_AMOUNT_MIN", validatorAmountRange.Min)
+	validatorAmountRange.Max = getEnvFloat("VALIDATOR_AMOUNT_MAX", validatorAmountRange.Max)
+	if raw := os.Getenv("PAYMENT_METHODS"); raw != "" {
+		var methods []string
+		for _, method := range strings.Split(raw, ",") {
+			if method = strings.TrimSpace(method); method != "" {
+				methods = append(methods, method)
+			}
+		}
+		if len(methods) == 0 {
+			warnIgnoredEnv("PAYMENT_METHODS", raw, errors.New("no methods listed"))
+		} else {
+			paymentMethods = methods
+		}
+	}
+	if raw := os.Getenv("VALIDATOR_ALLOWED_METHODS"); raw != "" {
+		validatorAllowedMethods = strings.Split(raw, ",")
+		for i := range validatorAllowedMethods {
+			validatorAllowedMethods[i] = strings.TrimSpace(validatorAllowedMethods[i])
+		}
+	}
+	if raw := os.Getenv("PAYMENT_VALIDATORS"); raw != "" {
+		if validators, err := enableValidators(raw); err != nil {
+			warnIgnoredEnv("PAYMENT_VALIDATORS", raw, err)
+		} else {
+			enabledValidators = validators
+		}
+	}
+	if os.Getenv("GATEWAY_MODE") == "timeout" {
+		gateway = timeoutGateway{}
+	}
+	idGeneratorSeed = int64(getEnvInt("PAYMENT_ID_SEED", int(idGeneratorSeed)))
+	switch kind := os.Getenv("PAYMENT_ID_GENERATOR"); kind {
+	case "", "uuid":
+	case "sequential":
+		idGeneratorKind, idGenerator = kind, &sequentialGenerator{}
+	case "seeded":
+		idGeneratorKind, idGenerator = kind, newSeededGenerator(idGeneratorSeed)
+	default:
+		warnIgnoredEnv("PAYMENT_ID_GENERATOR", kind, errors.New("unknown generator"))
+	}
+	if raw := os.Getenv("PAYMENT_ID_PATTERN"); raw != "" {
+		if pattern, err := regexp.Compile("^(?:" + raw + ")$"); err != nil {
+			warnIgnoredEnv("PAYMENT_ID_PATTERN", raw, err)
+		} else {
+			paymentIDPattern = pattern
+		}
+	}
+	if raw := os.Getenv("ALLOWED_ORDER_HOSTS"); raw != "" {
+		if hosts := parseAllowedHosts(raw); len(hosts) > 0 {
+			allowedHosts = hosts
+		} else {
+			warnIgnoredEnv("ALLOWED_ORDER_HOSTS", raw, errors.New("no valid host:port entries"))
+		}
+	}
+	if raw := os.Getenv("ORDER_SERVICE_URL"); raw != "" {
+		// Validation calls go through the SSRF allowlist, so a base it would
+		// refuse is rejected up front rather than failing every payment
+		base := strings.TrimRight(raw, "/")
+		if parsed, err := url.Parse(base); err == nil && parsed.RawQuery == "" && parsed.Fragment == "" && isAllowedURL(base) {
+			orderServiceURL = base
+		} else {
+			warnIgnoredEnv("ORDER_SERVICE_URL", raw, errors.New("must be an http URL whose host is in ALLOWED_ORDER_HOSTS"))
+		}
+	}
+	if raw := os.Getenv("WEBHOOK_URL"); raw != "" {
+		// Webhooks go through the same SSRF allowlist as order validation
+		if isAllowedURL(raw) {
+			webhookURL = raw
+		} else {
+			warnIgnoredEnv("WEBHOOK_URL", raw, errors.New("host is not in ALLOWED_ORDER_HOSTS"))
+		}
+	}
+	webhookSecret = os.Getenv("WEBHOOK_SECRET")
+	webhookTolerance = getEnvDuration("WEBHOOK_TOLERANCE", webhookTolerance)
+	if raw := os.Getenv("READINESS_ORDER_HOST"); raw != "" {
+		if hosts := parseAllowedHosts(raw); len(hosts) == 1 {
+			readinessOrderHost = hosts[0]
+		} else {
+			warnIgnoredEnv("READINESS_ORDER_HOST", raw, errors.New("expected one host:port"))
+		}
+	}
+	readinessTimeout = getEnvDuration("READINESS_TIMEOUT", readinessTimeout)
+	readinessCacheTTL = getEnvDuration("READINESS_CACHE_TTL", readinessCacheTTL)
+	orderCacheMaxEntries = getEnvInt("ORDER_CACHE_MAX_ENTRIES", orderCacheMaxEntries)
+	orderBreaker.threshold = getEnvInt("BREAKER_FAILURE_THRESHOLD", orderBreaker.threshold)
+	orderBreaker.cooldown = getEnvDuration("BREAKER_COOLDOWN", orderBreaker.cooldown)
+	validationTotalTimeout = getEnvDuration("VALIDATION_TOTAL_TIMEOUT", validationTotalTimeout)
+	if attempts := getEnvInt("VALIDATION_MAX_ATTEMPTS", validationMaxAttempts); attempts > 0 {
+		validationMaxAttempts = attempts
+	}
+	validationRetryBaseDelay = getEnvDuration("VALIDATION_RETRY_BASE_DELAY", validationRetryBaseDelay)
+	validationRetryMaxDelay = getEnvDuration("VALIDATION_RETRY_MAX_DELAY", validationRetryMaxDelay)
+	validationRetryBudget = getEnvInt("VALIDATION_RETRY_BUDGET", validationRetryBudget)
+	if refill := getEnvFloat("VALIDATION_RETRY_BUDGET_REFILL", validationRetryBudgetRefill); refill >= 0 {
+		validationRetryBudgetRefill = refill
+	} else {
+		warnIgnoredEnv("VALIDATION_RETRY_BUDGET_REFILL", os.Getenv("VALIDATION_RETRY_BUDGET_REFILL"), errors.New("must not be negative"))
+	}
+	idempotencyTTL = getEnvDuration("IDEMPOTENCY_TTL", idempotencyTTL)
+	orderBatchWindow = getEnvDuration("ORDER_BATCH_WINDOW", orderBatchWindow)
+	if size := getEnvInt("ORDER_BATCH_MAX", orderBatchMax); size > 0 {
+		orderBatchMax = size
+	}
+	switch kind := os.Getenv("PAYMENT_STORE"); kind {
+	case "":
+	case "memory", "file":
+		paymentStoreKind = kind
+	default:
+		warnIgnoredEnv("PAYMENT_STORE", kind, errors.New("unknown store"))
+	}
+	// Sequential and seeded IDs start over on restart and would collide with
+	// the payments the file store restored
+	if paymentStoreKind == "file" && idGeneratorKind != "uuid" {
+		warnIgnoredEnv("PAYMENT_ID_GENERATOR", idGeneratorKind, errors.New("only uuid IDs survive a restart of the file store"))
+		idGeneratorKind, idGenerator = "uuid", uuidGenerator{}
+	}
+	if path := os.Getenv("PAYMENT_STORE_PATH"); path != "" {
+		paymentStorePath = path
+	}
+	switch policy := os.Getenv("RESTORE_CONFLICT_POLICY"); policy {
+	case "":
+	case "reject", "skip", "overwrite":
+		restoreConflictPolicy = policy
+	default:
+		warnIgnoredEnv("RESTORE_CONFLICT_POLICY", policy, errors.New("unknown policy"))
+	}
+	listMaxAge = getEnvDuration("LIST_MAX_AGE", listMaxAge)
+	shutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", shutdownTimeout)
+	maxMetadataKeys = getEnvInt("PAYMENT_METADATA_MAX_KEYS", maxMetadataKeys)
+	maxMetadataBytes = getEnvInt("PAYMENT_METADATA_MAX_BYTES", maxMetadataBytes)
+	if limit := getEnvFloat("PAYMENT_MAX_AMOUNT", maxPaymentAmount); limit > 0 {
+		maxPaymentAmount = limit
+	}
+	matchOrderTotal = os.Getenv("AMOUNT_MATCH_ORDER_TOTAL") == "true"
+	amountToleranceAbs = getEnvFloat("AMOUNT_TOLERANCE_ABS", amountToleranceAbs)
+	amountToleranceRel = getEnvFloat("AMOUNT_TOLERANCE_REL", amountToleranceRel)
+	dailyOrderCap = getEnvFloat("DAILY_ORDER_CAP", dailyOrderCap)
+	maxStoredPayments = getEnvInt("MAX_STORED_PAYMENTS", maxStoredPayments)
+	maxHeapMB = getEnvInt("MAX_HEAP_MB", maxHeapMB)
+	if raw := os.Getenv("ACCEPTED_CONTENT_TYPES"); raw != "" {
+		acceptedContentTypes = strings.Split(raw, ",")
+		for i := range acceptedContentTypes {
+			acceptedContentTypes[i] = strings.ToLower(strings.TrimSpace(acceptedContentTypes[i]))
+		}
+	}
+	csrfEnforce = os.Getenv("CSRF_ENFORCE") == "true"
+	csrfTokenTTL = getEnvDuration("CSRF_TOKEN_TTL", csrfTokenTTL)
+	csrfMaxTokens = getEnvInt("CSRF_MAX_TOKENS", csrfMaxTokens)
+	nonceEnforce = os.Getenv("NONCE_ENFORCE") == "true"
+	nonceTTL = getEnvDuration("NONCE_TTL", nonceTTL)
+	gatewayTimeout = getEnvDuration("GATEWAY_TIMEOUT", gatewayTimeout)
+	paymentFailureThreshold = getEnvFloat("PAYMENT_FAILURE_THRESHOLD", paymentFailureThreshold)
+	if raw := os.Getenv("PAYMENT_METHOD_FAILURE_RATES"); raw != "" {
+		if rates, err := parseMethodFailureRates(raw); err != nil {
+			warnIgnoredEnv("PAYMENT_METHOD_FAILURE_RATES", raw, err)
+		} else {
+			methodFailureRates = rates
+		}
+	}
+	methodFailureSeed = int64(getEnvInt("PAYMENT_METHOD_FAILURE_SEED", int(methodFailureSeed)))
+	if limit := getEnvInt("BULK_MAX_ITEMS", maxBulkItems); limit > 0 {
+		maxBulkItems = limit
+	}
+	processingDelay = getEnvDuration("PROCESS_DELAY", processingDelay)
+	faultLatency = time.Duration(getEnvInt("FAULT_LATENCY_MS", int(faultLatency/time.Millisecond))) * time.Millisecond
+	if rate := getEnvFloat("FAULT_ERROR_RATE", faultErrorRate); rate <= 1 {
+		faultErrorRate = rate
+	} else {
+		warnIgnoredEnv("FAULT_ERROR_RATE", os.Getenv("FAULT_ERROR_RATE"), errors.New("must be between 0 and 1"))
+	}
+	faultSeed = int64(getEnvInt("FAULT_SEED", int(faultSeed)))
+	otlpEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	grpcPort = os.Getenv("GRPC_PORT")
+	rateLimitRPS = getEnvFloat("RATE_LIMIT_RPS", rateLimitRPS)
+	rateLimitBurst = getEnvInt("RATE_LIMIT_BURST", rateLimitBurst)
+	if raw := os.Getenv("TRUSTED_PROXIES"); raw != "" {
+		trustedProxies = parseTrustedProxies(raw)
+	}
+	asyncProcessDelay = getEnvDuration("ASYNC_PROCESS_DELAY", asyncProcessDelay)
+	pendingPaymentTTL = getEnvDuration("PENDING_PAYMENT_TTL", pendingPaymentTTL)
+	expirySweepInterval = getEnvDuration("EXPIRY_SWEEP_INTERVAL", expirySweepInterval)
+	switch mode := os.Getenv("PROCESS_MODE"); mode {
+	case "":
+	case "sync", "queue":
+		processMode = mode
+	default:
+		warnIgnoredEnv("PROCESS_MODE", mode, errors.New("unknown mode"))
+	}
+	if workers := getEnvInt("PROCESS_WORKERS", processWorkers); workers > 0 {
+		processWorkers = workers
+	}
+	if size := getEnvInt("PROCESS_QUEUE_SIZE", processQueueSize); size > 0 {
+		processQueueSize = size
+	}
+	processJobTTL = getEnvDuration("PROCESS_JOB_TTL", processJobTTL)
+	tlsCertFile = os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile = os.Getenv("TLS_KEY_FILE")
+	if raw := os.Getenv("TLS_CIPHER_SUITES"); raw != "" {
+		if suites, err := parseCipherSuites(raw); err != nil {
+			warnIgnoredEnv("TLS_CIPHER_SUITES", raw, err)
+		} else {
+			tlsCipherSuites = suites
+		}
+	}
+	if raw := os.Getenv("TLS_CURVES"); raw != "" {
+		if curves, err := parseCurves(raw); err != nil {
+			warnIgnoredEnv("TLS_CURVES", raw, err)
+		} else {
+			tlsCurves = curves
+		}
+	}
+	switch policy := os.Getenv("SANITIZATION_POLICY"); policy {
+	case "":
+	case sanitizeEscape, sanitizeStrip, sanitizeReject:
+		sanitizationPolicy = policy
+	default:
+		warnIgnoredEnv("SANITIZATION_POLICY", policy, errors.New("unknown policy"))
+	}
+	switch policy := os.Getenv("GATEWAY_TIMEOUT_POLICY"); policy {
+	case "":
+	case "fail", "defer":
+		gatewayTimeoutPolicy = policy
+	default:
+		warnIgnoredEnv("GATEWAY_TIMEOUT_POLICY", policy, errors.New("unknown policy"))
+	}
+}
+
+// warnIgnoredEnv logs that an environment setting was ignored and why. The
+// default stays in effect.
+func warnIgnoredEnv(name, raw string, err error) {
+	logger.Warn("ignoring invalid configuration", "env", name, "value", raw, "error", err)
+}
+
+// parseCurrencyFormats reads entries like "USD:$:prefix,EUR:€:suffix".
+// Malformed entries are skipped with a warning.
+func parseCurrencyFormats(raw string) map[string]currencyFormat {
+	formats := make(map[string]currencyFormat)
+	for _, entry := range strings.Split(raw, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			warnIgnoredEnv("CURRENCY_SYMBOLS", entry, errors.New("expected CODE:symbol:prefix|suffix"))
+			continue
+		}
+		formats[strings.ToUpper(parts[0])] = currencyFormat{
+			Symbol: parts[1],
+			Suffix: strings.EqualFold(parts[2], "suffix"),
+		}
+	}
+	return formats
+}
+
+// parseAllowedHosts reads a comma-separated host:port list such as
+// "orders.staging:8002,localhost:8002". Malformed entries are skipped with
+// a warning.
+func parseAllowedHosts(raw string) []string {
+	var hosts []string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		host, port, err := net.SplitHostPort(entry)
+		if err == nil && host == "" {
+			err = errors.New("missing host")
+		}
+		if err != nil {
+			warnIgnoredEnv("ALLOWED_ORDER_HOSTS", entry, err)
+			continue
+		}
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			warnIgnoredEnv("ALLOWED_ORDER_HOSTS", entry, fmt.Errorf("invalid port %q", port))
+			continue
+		}
+		hosts = append(hosts, entry)
+	}
+	return hosts
+}
+
+// parseTrustedProxies reads a comma-separated list of IPs and CIDRs such
+// as "10.0.0.0/8,192.168.1.10". Malformed entries are skipped with a
+// warning.
+func parseTrustedProxies(raw string) []string {
+	var proxies []string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			warnIgnoredEnv("TRUSTED_PROXIES", entry, errors.New("expected an IP or CIDR"))
+			continue
+		}
+		proxies = append(proxies, entry)
+	}
+	return proxies
+}
+
+// getEnvDuration parses a Go duration (e.g. "500ms") from the environment,
+// keeping the fallback when unset or invalid.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err == nil && value <= 0 {
+		err = errors.New("must be positive")
+	}
+	if err != nil {
+		warnIgnoredEnv(key, raw, err)
+		return fallback
+	}
+	return value
+}
+
+// getEnvInt parses a non-negative integer from the environment, keeping the
+// fallback when unset or invalid.
+func getEnvInt(key string, fallback int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err == nil && value < 0 {
+		err = errors.New("must not be negative")
+	}
+	if err != nil {
+		warnIgnoredEnv(key, raw, err)
+		return fallback
+	}
+	return value
+}
+
+// getEnvFloat parses a non-negative number from the environment, keeping
+// the fallback when unset or invalid.
+func getEnvFloat(key string, fallback float64) float64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err == nil && (value < 0 || math.IsNaN(value) || math.IsInf(value, 0)) {
+		err = errors.New("must be a finite, non-negative number")
+	}
+	if err != nil {
+		warnIgnoredEnv(key, raw, err)
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// captureLogs sends the service's logs to a buffer for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	setVar(t, &logger, slog.New(slog.NewJSONHandler(&buf, nil)))
+	return &buf
+}
+
+func TestInvalidSettingsAreLoggedAsWarnings(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   string
+		value string
+		read  func()
+	}{
+		{"duration", "GATEWAY_TIMEOUT", "soon", func() { getEnvDuration("GATEWAY_TIMEOUT", time.Second) }},
+		{"negative int", "RATE_LIMIT_BURST", "-1", func() { getEnvInt("RATE_LIMIT_BURST", 1) }},
+		{"float", "RATE_LIMIT_RPS", "fast", func() { getEnvFloat("RATE_LIMIT_RPS", 1) }},
+		{"host entry", "ALLOWED_ORDER_HOSTS", "orders:0", func() { parseAllowedHosts("orders:0") }},
+		{"currency entry", "CURRENCY_SYMBOLS", "EUR", func() { parseCurrencyFormats("EUR") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			t.Setenv(tt.env, tt.value)
+			tt.read()
+
+			var entry map[string]any
+			if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+				t.Fatalf("log output %q: %v", logs.String(), err)
+			}
+			if entry["level"] != "WARN" || entry["env"] != tt.env || entry["value"] != tt.value || entry["error"] == nil {
+				t.Fatalf("log entry = %v, want a warning naming %s=%q and the error", entry, tt.env, tt.value)
+			}
+		})
+	}
+}
+
+func TestAllowedOrderHosts(t *testing.T) {
+	r := newTestRouter(t)
+	service := newOrderService(t, ordersFound)
+	host := strings.TrimPrefix(service.URL, "http://")
+
+	setVar(t, &allowedHosts, parseAllowedHosts(" orders , "+host+" ,:8002"))
+	if len(allowedHosts) != 1 || allowedHosts[0] != host {
+		t.Fatalf("allowed hosts = %q, want only %q", allowedHosts, host)
+	}
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+
+	allowedHosts = parseAllowedHosts("order-service:8002")
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("create with the order-service host not allowed = %d, want 400", w.Code)
+	}
+}
+
+func TestOrderServiceURL(t *testing.T) {
+	r := newTestRouter(t)
+	paths := make(chan string, 1)
+	service := newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		paths <- req.URL.Path
+		ordersFound(w, req)
+	})
+
+	orderServiceURL = service.URL + "/v1"
+	orderID := uuid.NewString()
+	mustCreatePayment(t, r, orderID, 10, "pix")
+	if got := <-paths; got != "/v1/orders/"+orderID {
+		t.Fatalf("validation requested %s, want /v1/orders/%s under the configured base", got, orderID)
+	}
+
+	orderServiceURL = "http://payments.example.com:8002"
+	if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix")); w.Code != http.StatusBadRequest {
+		t.Fatalf("create with a base outside ALLOWED_ORDER_HOSTS = %d, want 400", w.Code)
+	}
+}
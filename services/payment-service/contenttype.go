@@ -0,0 +1,42 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// acceptedContentTypes are the media types mutating requests may carry.
+var acceptedContentTypes = []string{"application/json"}
+
+// contentTypeMiddleware rejects mutating requests whose body is not in an
+// accepted media type with 415, instead of letting binding fail obscurely.
+// Requests without a body (e.g. process, cancel) are let through.
+func contentTypeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		if method != http.MethodPost && method != http.MethodPut && method != http.MethodPatch {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength == 0 {
+			c.Next()
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+		if err == nil {
+			for _, accepted := range acceptedContentTypes {
+				if mediaType == accepted {
+					c.Next()
+					return
+				}
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":    "Unsupported Content-Type",
+			"accepted": acceptedContentTypes,
+		})
+	}
+}
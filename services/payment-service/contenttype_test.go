@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMutatingRequestsRequireJSON(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	body := createPaymentBody(uuid.NewString(), 10, "pix")
+
+	tests := []struct {
+		contentType string
+		want        int
+	}{
+		{"application/json", http.StatusCreated},
+		{"application/json; charset=utf-8", http.StatusCreated},
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		w := doRequest(t, r, http.MethodPost, "/payments", body, "Content-Type", tt.contentType)
+		if w.Code != tt.want {
+			t.Errorf("POST /payments with Content-Type %q = %d %s, want %d", tt.contentType, w.Code, w.Body.String(), tt.want)
+		}
+		body = createPaymentBody(uuid.NewString(), 10, "pix")
+	}
+
+	// Bodiless writes need no Content-Type
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	mustProcessPayment(t, r, payment.ID)
+}
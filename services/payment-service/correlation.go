@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// correlationIDHeader ties together the log lines of one request across
+// the services of the suite.
+const correlationIDHeader = "X-Correlation-ID"
+
+type correlationIDKey struct{}
+
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// correlationID returns the request's correlation ID, or "" outside a
+// request.
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// correlationIDOrNew keeps a well-formed incoming ID and makes one up
+// otherwise. The format rules are the Idempotency-Key ones.
+func correlationIDOrNew(id string) string {
+	if isValidIdempotencyKey(id) {
+		return id
+	}
+	return uuid.NewString()
+}
+
+// correlationMiddleware adopts the caller's X-Correlation-ID, or generates
+// one, and echoes it on the response. Log lines written with the request
+// context and the order-service call carry it.
+func correlationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := correlationIDOrNew(c.GetHeader(correlationIDHeader))
+		c.Header(correlationIDHeader, id)
+		c.Request = c.Request.WithContext(withCorrelationID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// correlationUnaryInterceptor does for gRPC calls what
+// correlationMiddleware does for HTTP, using x-correlation-id metadata.
+func correlationUnaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var incoming string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(correlationIDHeader); len(values) > 0 {
+			incoming = values[0]
+		}
+	}
+	id := correlationIDOrNew(incoming)
+	grpc.SetHeader(ctx, metadata.Pairs(correlationIDHeader, id))
+	return handler(withCorrelationID(ctx, id), req)
+}
+
+// correlationHandler adds the correlation ID of the context a record was
+// logged with, so the *Context logging methods tag request log lines.
+type correlationHandler struct {
+	slog.Handler
+}
+
+func (h correlationHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := correlationID(ctx); id != "" {
+		record.AddAttrs(slog.String("correlation_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h correlationHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return correlationHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h correlationHandler) WithGroup(name string) slog.Handler {
+	return correlationHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCorrelationID(t *testing.T) {
+	r := newTestRouter(t)
+	forwarded := make(chan string, 1)
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		forwarded <- req.Header.Get(correlationIDHeader)
+		ordersFound(w, req)
+	})
+	var logs bytes.Buffer
+	setVar(t, &logger, slog.New(correlationHandler{slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})}))
+
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"), correlationIDHeader, "run-42.case-7")
+	if w.Code != http.StatusCreated || w.Header().Get(correlationIDHeader) != "run-42.case-7" {
+		t.Fatalf("create = %d with %s %q, want 201 echoing run-42.case-7", w.Code, correlationIDHeader, w.Header().Get(correlationIDHeader))
+	}
+	if got := <-forwarded; got != "run-42.case-7" {
+		t.Fatalf("order-service got %s %q, want run-42.case-7", correlationIDHeader, got)
+	}
+	entry := findLog(logEntries(t, &logs), "order validation response")
+	if entry == nil || entry["correlation_id"] != "run-42.case-7" {
+		t.Fatalf("validation log = %v, want it tagged with the correlation ID", entry)
+	}
+
+	for _, incoming := range []string{"", "has spaces in it"} {
+		w := doRequest(t, r, http.MethodGet, "/health", "", correlationIDHeader, incoming)
+		if _, err := uuid.Parse(w.Header().Get(correlationIDHeader)); err != nil {
+			t.Errorf("%s for incoming %q = %q, want a generated UUID", correlationIDHeader, incoming, w.Header().Get(correlationIDHeader))
+		}
+	}
+}
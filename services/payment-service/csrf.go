@@ -0,0 +1,61 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+var (
+	// Reject mutating requests without a token issued by this service (CSRF_ENFORCE)
+	csrfEnforce = false
+	// How long an issued token stays valid (CSRF_TOKEN_TTL)
+	csrfTokenTTL = time.Hour
+	// Most tokens remembered at once (CSRF_MAX_TOKENS, 0 for unbounded);
+	// beyond it the oldest are forgotten before they expire
+	csrfMaxTokens = 10000
+	// Tokens handed out on GET requests, with the time they were issued
+	issuedCSRFTokens = make(map[string]time.Time)
+	// Issued tokens from oldest (front) to newest (back)
+	csrfTokenOrder = list.New()
+	csrfMutex      = sync.Mutex{}
+)
+
+// issueCSRFToken generates a token and remembers it for csrfTokenTTL.
+func issueCSRFToken() string {
+	token := generateCSRFToken()
+
+	csrfMutex.Lock()
+	defer csrfMutex.Unlock()
+
+	current := clock()
+	pruneCSRFTokens(current)
+	issuedCSRFTokens[token] = current
+	csrfTokenOrder.PushBack(token)
+	return token
+}
+
+// pruneCSRFTokens forgets expired tokens and, when at csrfMaxTokens, the
+// oldest ones so a new token fits. Tokens are issued in order, so both
+// kinds are at the front. The caller must hold csrfMutex.
+func pruneCSRFTokens(current time.Time) {
+	for oldest := csrfTokenOrder.Front(); oldest != nil; oldest = csrfTokenOrder.Front() {
+		token := oldest.Value.(string)
+		full := csrfMaxTokens > 0 && csrfTokenOrder.Len() >= csrfMaxTokens
+		if !full && current.Sub(issuedCSRFTokens[token]) <= csrfTokenTTL {
+			return
+		}
+		csrfTokenOrder.Remove(oldest)
+		delete(issuedCSRFTokens, token)
+	}
+}
+
+// validCSRFToken reports whether token was issued by this service and has
+// not expired. Tokens may be used for several requests within their TTL.
+func validCSRFToken(token string) bool {
+	csrfMutex.Lock()
+	defer csrfMutex.Unlock()
+
+	issuedAt, exists := issuedCSRFTokens[token]
+	return exists && clock().Sub(issuedAt) <= csrfTokenTTL
+}
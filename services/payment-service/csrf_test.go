@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fetchCSRFToken makes a GET request and returns the token it hands out.
+func fetchCSRFToken(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	token := doRequest(t, handler, http.MethodGet, "/payments", "").Header().Get("X-CSRF-Token")
+	if token == "" {
+		t.Fatal("GET /payments issued no CSRF token")
+	}
+	return token
+}
+
+func TestCSRFTokensAreCapped(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &csrfEnforce, true)
+	setVar(t, &csrfMaxTokens, 2)
+
+	oldest := fetchCSRFToken(t, r)
+	fetchCSRFToken(t, r)
+	newest := fetchCSRFToken(t, r)
+
+	csrfMutex.Lock()
+	remembered := len(issuedCSRFTokens)
+	csrfMutex.Unlock()
+	if remembered != 2 {
+		t.Fatalf("remembered %d tokens, want 2", remembered)
+	}
+
+	body := createPaymentBody(uuid.NewString(), 10, "pix")
+	if w := doRequest(t, r, http.MethodPost, "/payments", body, "X-CSRF-Token", oldest); w.Code != http.StatusForbidden {
+		t.Fatalf("create with an evicted token = %d %s, want 403", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodPost, "/payments", body, "X-CSRF-Token", newest); w.Code != http.StatusCreated {
+		t.Fatalf("create with the newest token = %d %s, want 201", w.Code, w.Body.String())
+	}
+}
+
+func TestExpiredCSRFTokensAreForgotten(t *testing.T) {
+	r := newTestRouter(t)
+	setVar(t, &csrfEnforce, true)
+	setVar(t, &csrfTokenTTL, time.Minute)
+	advance := useFakeClock(t)
+
+	expired := fetchCSRFToken(t, r)
+	advance(2 * time.Minute)
+	if validCSRFToken(expired) {
+		t.Fatal("expired token still valid")
+	}
+	fetchCSRFToken(t, r)
+
+	csrfMutex.Lock()
+	_, kept := issuedCSRFTokens[expired]
+	csrfMutex.Unlock()
+	if kept {
+		t.Fatal("expired token still remembered after issuing another")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const defaultCurrency = "USD"
+
+// currencyDecimals lists the accepted ISO 4217 currency codes with the
+// number of decimal places (minor units) each allows.
+var currencyDecimals = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"BRL": 2,
+	"CAD": 2,
+	"AUD": 2,
+	"CHF": 2,
+	"JPY": 0,
+	"KRW": 0,
+}
+
+// validateCurrencyAmount checks the currency is accepted and, for
+// zero-decimal currencies such as JPY, that the amount is whole.
+func validateCurrencyAmount(currency string, amount float64) error {
+	decimals, exists := currencyDecimals[currency]
+	if !exists {
+		return fmt.Errorf("unsupported currency %q", currency)
+	}
+	if decimals == 0 && amount != math.Trunc(amount) {
+		return fmt.Errorf("%s amounts cannot have a fractional part", currency)
+	}
+	return nil
+}
+
+// Amount is a monetary value. It is written as a JSON number, or as a
+// string such as "19.99" when amountsAsStrings is set, and accepts either
+// form on input. On its own an Amount has no currency, so the string form
+// has two decimals; Payment writes its amounts with its currency's.
+type Amount float64
+
+var amountsAsStrings = false
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	if amountsAsStrings {
+		return json.Marshal(strconv.FormatFloat(float64(a), 'f', 2, 64))
+	}
+	return json.Marshal(float64(a))
+}
+
+// MarshalJSON writes Amount and RefundAmount with the decimal places of
+// the payment's currency when amountsAsStrings is set, so a JPY payment
+// reads "1500" rather than "1500.00".
+func (p Payment) MarshalJSON() ([]byte, error) {
+	type plainPayment Payment
+	if !amountsAsStrings {
+		return json.Marshal(plainPayment(p))
+	}
+	refunded := ""
+	if p.RefundAmount != 0 {
+		refunded = amountString(float64(p.RefundAmount), p.Currency)
+	}
+	return json.Marshal(struct {
+		plainPayment
+		Amount       string `json:"amount"`
+		RefundAmount string `json:"refund_amount,omitempty"`
+	}{plainPayment(p), amountString(float64(p.Amount), p.Currency), refunded})
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var raw string
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid amount %q", raw)
+		}
+		*a = Amount(value)
+		return nil
+	}
+	var value float64
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*a = Amount(value)
+	return nil
+}
+
+// currencyFormat describes how a currency symbol is placed around an amount.
+type currencyFormat struct {
+	Symbol string `json:"symbol"`
+	Suffix bool   `json:"suffix"`
+}
+
+var currencyFormats = map[string]currencyFormat{
+	"USD": {Symbol: "$"},
+	"EUR": {Symbol: "€", Suffix: true},
+	"GBP": {Symbol: "£"},
+	"BRL": {Symbol: "R$"},
+	"JPY": {Symbol: "¥"},
+}
+
+// diagnosedPayment is a payment created with ?debug=true: the payment's
+// own fields plus the validation trace. It is spliced by hand because an
+// embedded Payment would promote Payment.MarshalJSON and drop the trace.
+type diagnosedPayment struct {
+	payment    *Payment
+	diagnostic *validationTrace
+}
+
+func (d diagnosedPayment) MarshalJSON() ([]byte, error) {
+	body, err := json.Marshal(d.payment)
+	if err != nil || d.diagnostic == nil {
+		return body, err
+	}
+	diagnostic, err := json.Marshal(d.diagnostic)
+	if err != nil {
+		return nil, err
+	}
+	body = append(body[:len(body)-1], `,"diagnostic":`...)
+	body = append(body, diagnostic...)
+	return append(body, '}'), nil
+}
+
+// amountDecimals is how many decimal places amounts in currency are
+// written with: its minor units, or 2 for a currency not listed.
+func amountDecimals(currency string) int {
+	if decimals, known := currencyDecimals[currency]; known {
+		return decimals
+	}
+	return 2
+}
+
+// amountString writes an amount with the currency's decimal places and no
+// symbol, e.g. "19.90" or "1500".
+func amountString(amount float64, currency string) string {
+	return strconv.FormatFloat(amount, 'f', amountDecimals(currency), 64)
+}
+
+// formatAmount renders an amount for display with the currency's decimal
+// places, falling back to the ISO code when no symbol is configured.
+func formatAmount(amount float64, currency string) string {
+	decimals := amountDecimals(currency)
+	format, exists := currencyFormats[currency]
+	if !exists {
+		return fmt.Sprintf("%.*f %s", decimals, amount, currency)
+	}
+	if format.Suffix {
+		return fmt.Sprintf("%.*f %s", decimals, amount, format.Symbol)
+	}
+	return fmt.Sprintf("%s%.*f", format.Symbol, decimals, amount)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		want     string
+	}{
+		{19.9, "USD", "$19.90"},
+		{19.9, "EUR", "19.90 €"},
+		{1500, "JPY", "¥1500"},
+		{7, "CHF", "7.00 CHF"},
+	}
+	for _, tt := range tests {
+		if got := formatAmount(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("formatAmount(%v, %s) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestCurrencySymbolsAreConfigurable(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &currencyFormats, parseCurrencyFormats("usd:US$:suffix"))
+
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	if payment.FormattedAmount != "10.00 US$" {
+		t.Fatalf("formatted_amount = %q, want %q", payment.FormattedAmount, "10.00 US$")
+	}
+	w := doRequest(t, r, http.MethodGet, "/payments/"+payment.ID, "")
+	if fetched := decodeJSON[Payment](t, w); fetched.FormattedAmount != payment.FormattedAmount {
+		t.Fatalf("GET formatted_amount = %q, want %q", fetched.FormattedAmount, payment.FormattedAmount)
+	}
+}
+
+func TestAmountsAsStrings(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	body := `{"order_id":"` + uuid.NewString() + `","amount":"19.90","method":"pix"}`
+
+	w := doRequest(t, r, http.MethodPost, "/payments", body)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"amount":19.9,`) {
+		t.Fatalf("create with a string amount = %d %s, want a numeric amount back", w.Code, w.Body.String())
+	}
+
+	setVar(t, &amountsAsStrings, true)
+	w = doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 19.9, "pix"))
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"amount":"19.90"`) {
+		t.Fatalf("create with AMOUNT_AS_STRING = %d %s, want the amount as a string", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, r, http.MethodPost, "/payments", `{"order_id":"`+uuid.NewString()+`","amount":1500,"method":"pix","currency":"JPY"}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"amount":"1500"`) {
+		t.Fatalf("JPY create with AMOUNT_AS_STRING = %d %s, want the amount without decimals", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, r, http.MethodPost, "/payments", `{"order_id":"`+uuid.NewString()+`","amount":"lots","method":"pix"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("create with a non-numeric amount = %d, want 400", w.Code)
+	}
+}
+
+func TestPaymentCurrency(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	create := func(amount, currency string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"order_id":%q,"amount":%s,"method":"pix","currency":%q}`, uuid.NewString(), amount, currency)
+		return doRequest(t, r, http.MethodPost, "/payments", body)
+	}
+
+	if payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix"); payment.Currency != "USD" {
+		t.Fatalf("default currency = %q, want USD", payment.Currency)
+	}
+	w := create("10.5", "EUR")
+	if got := decodeJSON[Payment](t, w); w.Code != http.StatusCreated || got.Currency != "EUR" {
+		t.Fatalf("EUR payment = %d %s, want 201 in EUR", w.Code, w.Body.String())
+	}
+	if w := create("1500", "JPY"); w.Code != http.StatusCreated {
+		t.Fatalf("whole JPY payment = %d %s, want 201", w.Code, w.Body.String())
+	}
+	if w := create("1500.5", "JPY"); w.Code != http.StatusBadRequest {
+		t.Fatalf("fractional JPY payment = %d, want 400", w.Code)
+	}
+	if w := create("10", "XYZ"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unsupported currency = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// draining is set while the instance is being taken out of rotation: it
+// reports not-ready and refuses new payments but keeps serving everything
+// else until the orchestrator removes it.
+var draining atomic.Bool
+
+func setDraining(value bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		draining.Store(value)
+		c.JSON(http.StatusOK, gin.H{"draining": value})
+	}
+}
+
+// drainMiddleware refuses new work with 503 while draining.
+func drainMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if draining.Load() {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Instance is draining"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// readinessCheck reports whether the instance should receive traffic: it
+// must not be draining and the order-service must accept connections.
+// /health stays a pure liveness check.
+func readinessCheck(c *gin.Context) {
+	if draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining", "service": "payment-service"})
+		return
+	}
+	target, err := checkOrderServiceReachable()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":        "unavailable",
+			"service":       "payment-service",
+			"order_service": gin.H{"host": target, "reachable": false, "error": err.Error()},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "ready",
+		"service":       "payment-service",
+		"order_service": gin.H{"host": target, "reachable": true},
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDrainAndUndrain(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	admin := useAdminToken(t)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+
+	if w := doRequest(t, r, http.MethodPost, "/admin/drain", "", admin...); w.Code != http.StatusOK {
+		t.Fatalf("drain = %d %s, want 200", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodGet, "/health/ready", ""); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readiness while draining = %d, want 503", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodGet, "/health", ""); w.Code != http.StatusOK {
+		t.Fatalf("liveness while draining = %d, want 200", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix")); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("create while draining = %d, want 503", w.Code)
+	}
+	mustProcessPayment(t, r, payment.ID)
+
+	if w := doRequest(t, r, http.MethodPost, "/admin/undrain", "", admin...); w.Code != http.StatusOK {
+		t.Fatalf("undrain = %d %s, want 200", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodGet, "/health/ready", ""); w.Code != http.StatusOK {
+		t.Fatalf("readiness after undraining = %d %s, want 200", w.Code, w.Body.String())
+	}
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+}
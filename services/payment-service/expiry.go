@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	// How long a payment may stay pending before it expires (PENDING_PAYMENT_TTL)
+	pendingPaymentTTL = time.Hour
+	// How often pending payments are checked for expiry (EXPIRY_SWEEP_INTERVAL)
+	expirySweepInterval = time.Minute
+)
+
+// expireStalePayments moves payments pending for longer than
+// pendingPaymentTTL to "expired" and reports how many it moved. Deferred
+// payments are waiting on the gateway, not the client, and are left alone.
+func expireStalePayments() int {
+	now := clock()
+	expired := 0
+
+	paymentsMutex.Lock()
+	defer paymentsMutex.Unlock()
+	for _, payment := range payments.List() {
+		if payment.Status != "pending" || now.Sub(payment.CreatedAt) < pendingPaymentTTL {
+			continue
+		}
+		payment.Status = "expired"
+		payment.ExpiredAt = &now
+		persistPayment(payment)
+		releaseDailyTotal(payment, float64(payment.Amount))
+		paymentsExpired.Inc()
+		expired++
+	}
+	return expired
+}
+
+// startExpirySweeper expires stale payments every expirySweepInterval until
+// ctx is cancelled.
+func startExpirySweeper(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(expirySweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if expired := expireStalePayments(); expired > 0 {
+					logger.Info("expired stale pending payments", "count", expired, "ttl", pendingPaymentTTL.String())
+				}
+			}
+		}
+	}()
+	return &wg
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStalePendingPaymentsExpire(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+
+	stale := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	completed := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	advance(pendingPaymentTTL)
+	fresh := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+
+	if expired := expireStalePayments(); expired != 1 {
+		t.Fatalf("expired %d payments, want only the stale pending one", expired)
+	}
+	got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+stale.ID, ""))
+	if got.Status != "expired" || got.ExpiredAt == nil {
+		t.Fatalf("stale payment = %s (expired_at %v), want expired with a timestamp", got.Status, got.ExpiredAt)
+	}
+	for id, want := range map[string]string{completed.ID: "completed", fresh.ID: "pending"} {
+		if got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+id, "")); got.Status != want {
+			t.Errorf("payment %s = %s, want %s", id, got.Status, want)
+		}
+	}
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+stale.ID+"/process", "", "If-Match", "*"); w.Code != http.StatusConflict {
+		t.Fatalf("process an expired payment = %d %s, want 409", w.Code, w.Body.String())
+	}
+}
+
+func TestExpirySweeperStopsWithContext(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &pendingPaymentTTL, time.Millisecond)
+	setVar(t, &expirySweepInterval, 5*time.Millisecond)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sweeper := startExpirySweeper(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+payment.ID, "")).Status != "expired" {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatal("sweeper did not expire the stale payment")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	sweeper.Wait()
+}
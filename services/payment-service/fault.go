@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Delay added to every write request on the payment API (FAULT_LATENCY_MS)
+	faultLatency time.Duration = 0
+	// Probability in [0, 1] that a write request fails with 500 (FAULT_ERROR_RATE)
+	faultErrorRate = 0.0
+	// Seed for the failure draws so a run can be replayed (FAULT_SEED); 0 seeds from the clock
+	faultSeed  int64 = 0
+	faultRand  *rand.Rand
+	faultMutex = sync.Mutex{}
+)
+
+// faultInjectionEnabled reports whether any fault is configured.
+func faultInjectionEnabled() bool {
+	return faultLatency > 0 || faultErrorRate > 0
+}
+
+// drawFault reports whether this request should fail. A fixed seed yields
+// the same sequence of failures for the same sequence of requests.
+func drawFault() bool {
+	if faultErrorRate <= 0 {
+		return false
+	}
+	if faultErrorRate >= 1 {
+		return true
+	}
+	faultMutex.Lock()
+	defer faultMutex.Unlock()
+	if faultRand == nil {
+		seed := faultSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		faultRand = rand.New(rand.NewSource(seed))
+	}
+	return faultRand.Float64() < faultErrorRate
+}
+
+// faultInjectionMiddleware delays and randomly fails write requests to the
+// payment endpoints so client retries and timeouts can be exercised.
+// Health, metrics and admin routes are never affected.
+func faultInjectionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// DELETE /payments is the admin reset, not part of the payment API
+		isReset := c.Request.Method == http.MethodDelete && c.FullPath() == "/payments"
+		if !faultInjectionEnabled() || isReset || !strings.HasPrefix(c.Request.URL.Path, "/payments") {
+			c.Next()
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		if faultLatency > 0 {
+			timer := time.NewTimer(faultLatency)
+			select {
+			case <-timer.C:
+			case <-c.Request.Context().Done():
+				timer.Stop()
+				c.Abort()
+				return
+			}
+		}
+		if drawFault() {
+			c.Header("X-Fault-Injected", "error")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Injected fault"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFaultErrorRate(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+
+	setVar(t, &faultErrorRate, 1.0)
+	for i := 0; i < 10; i++ {
+		w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"))
+		if w.Code != http.StatusInternalServerError || w.Header().Get("X-Fault-Injected") != "error" {
+			t.Fatalf("create at a 100%% error rate = %d %s, want an injected 500", w.Code, w.Body.String())
+		}
+	}
+	for _, target := range []string{"/payments", "/health", "/metrics"} {
+		if w := doRequest(t, r, http.MethodGet, target, ""); w.Code != http.StatusOK {
+			t.Errorf("GET %s at a 100%% error rate = %d, want 200", target, w.Code)
+		}
+	}
+
+	faultErrorRate = 0
+	for i := 0; i < 10; i++ {
+		mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	}
+}
+
+// A fixed FAULT_SEED replays the same failures for the same requests.
+func TestFaultSeedIsDeterministic(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &faultErrorRate, 0.5)
+	setVar(t, &faultSeed, 42)
+
+	run := func() []int {
+		faultMutex.Lock()
+		faultRand = nil
+		faultMutex.Unlock()
+		codes := make([]int, 20)
+		for i := range codes {
+			codes[i] = doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix")).Code
+		}
+		return codes
+	}
+	first, second := run(), run()
+	failures := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("seeded runs differ: %v and %v", first, second)
+		}
+		if first[i] == http.StatusInternalServerError {
+			failures++
+		}
+	}
+	if failures == 0 || failures == len(first) {
+		t.Fatalf("seeded run at a 50%% error rate = %v, want a mix of failures and successes", first)
+	}
+}
+
+func TestFaultLatency(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &faultLatency, 50*time.Millisecond)
+
+	start := time.Now()
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	if elapsed := time.Since(start); elapsed < faultLatency {
+		t.Fatalf("create took %v, want at least the injected %v", elapsed, faultLatency)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// PaymentGateway charges a payment and reports the resulting status.
+type PaymentGateway interface {
+	Charge(ctx context.Context, payment *Payment) (string, error)
+}
+
+// thresholdGateway fails payments above paymentFailureThreshold, fails
+// the rest at their method's methodFailureRates, and completes the others.
+type thresholdGateway struct{}
+
+func (thresholdGateway) Charge(ctx context.Context, payment *Payment) (string, error) {
+	if float64(payment.Amount) > paymentFailureThreshold {
+		return "failed", nil
+	}
+	if drawMethodFailure(payment.Method, isDryRun(ctx)) {
+		return "failed", nil
+	}
+	return "completed", nil
+}
+
+// drawMethodFailure reports whether a payment of this method fails by
+// chance. A fixed seed replays the same outcomes for the same sequence of
+// charges. Dry runs draw from their own source so predicting an outcome
+// doesn't shift the outcomes of real charges.
+func drawMethodFailure(method string, dryRun bool) bool {
+	rate := methodFailureRates[method]
+	if rate <= 0 {
+		return false
+	}
+	if rate >= 1 {
+		return true
+	}
+	methodFailureMutex.Lock()
+	defer methodFailureMutex.Unlock()
+	source := &methodFailureRand
+	if dryRun {
+		source = &dryRunFailureRand
+	}
+	if *source == nil {
+		seed := methodFailureSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		*source = rand.New(rand.NewSource(seed))
+	}
+	return (*source).Float64() < rate
+}
+
+type dryRunKey struct{}
+
+// withDryRun marks a charge as a ?dry_run=true prediction.
+func withDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+func isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// timeoutGateway never answers, simulating a gateway that hangs until the
+// caller's deadline passes.
+type timeoutGateway struct{}
+
+func (timeoutGateway) Charge(ctx context.Context, payment *Payment) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+var (
+	gateway        PaymentGateway = thresholdGateway{}
+	gatewayTimeout                = 5 * time.Second
+	// Amounts above this fail at the threshold gateway (PAYMENT_FAILURE_THRESHOLD)
+	paymentFailureThreshold = 1000.0
+	// What a gateway timeout does to the payment: "fail" or "defer"
+	gatewayTimeoutPolicy = "fail"
+	// Probability in [0, 1] that a payment of each method fails at the
+	// threshold gateway (PAYMENT_METHOD_FAILURE_RATES, a JSON object)
+	methodFailureRates = map[string]float64{}
+	// Seed for the method failure draws (PAYMENT_METHOD_FAILURE_SEED); 0 seeds from the clock
+	methodFailureSeed  int64 = 0
+	methodFailureRand  *rand.Rand
+	dryRunFailureRand  *rand.Rand
+	methodFailureMutex = sync.Mutex{}
+)
+
+// parseMethodFailureRates reads a JSON object such as {"boleto": 0.3}.
+func parseMethodFailureRates(raw string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	if err := json.Unmarshal([]byte(raw), &rates); err != nil {
+		return nil, err
+	}
+	for method, rate := range rates {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("rate for %s must be between 0 and 1", method)
+		}
+	}
+	return rates, nil
+}
+
+// chargePayment runs the gateway under gatewayTimeout and resolves a
+// timeout according to gatewayTimeoutPolicy.
+func chargePayment(parent context.Context, payment *Payment) (string, error) {
+	ctx, cancel := context.WithTimeout(parent, gatewayTimeout)
+	defer cancel()
+
+	status, err := gateway.Charge(ctx, payment)
+	if parentErr := parent.Err(); parentErr != nil {
+		// The caller gave up; that says nothing about the gateway
+		return "", parentErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		if gatewayTimeoutPolicy == "defer" {
+			return "deferred", nil
+		}
+		return "failed", nil
+	}
+	return status, err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDryRunPredictsWithoutChangingThePayment(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+
+	for amount, want := range map[float64]string{10: "completed", paymentFailureThreshold + 1: "failed"} {
+		payment := mustCreatePayment(t, r, uuid.NewString(), amount, "pix")
+		w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process?dry_run=true", "", "If-Match", "*")
+		prediction := decodeJSON[struct {
+			DryRun          bool   `json:"dry_run"`
+			CurrentStatus   string `json:"current_status"`
+			PredictedStatus string `json:"predicted_status"`
+		}](t, w)
+		if w.Code != http.StatusOK || !prediction.DryRun || prediction.CurrentStatus != "pending" || prediction.PredictedStatus != want {
+			t.Fatalf("dry run for %v = %d %s, want %s predicted for a pending payment", amount, w.Code, w.Body.String(), want)
+		}
+
+		got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+payment.ID, ""))
+		if got.Status != "pending" || got.ProcessedAt != nil || got.Version != payment.Version {
+			t.Fatalf("payment after a dry run = %+v, want it unchanged", got)
+		}
+	}
+}
+
+// Predicting outcomes with ?dry_run=true leaves the seeded sequence of real
+// outcomes as it would have been without the predictions.
+func TestDryRunsDoNotShiftSeededOutcomes(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &methodFailureRates, map[string]float64{"pix": 0.5})
+	setVar(t, &methodFailureSeed, 42)
+
+	outcomes := func(dryRuns bool) []string {
+		resetState()
+		var statuses []string
+		for i := 0; i < 20; i++ {
+			payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+			if dryRuns {
+				w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process?dry_run=true", "", "If-Match", "*")
+				if w.Code != http.StatusOK {
+					t.Fatalf("dry run = %d %s", w.Code, w.Body.String())
+				}
+			}
+			w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process", "", "If-Match", "*")
+			if w.Code != http.StatusOK {
+				t.Fatalf("process = %d %s", w.Code, w.Body.String())
+			}
+			statuses = append(statuses, decodeJSON[Payment](t, w).Status)
+		}
+		return statuses
+	}
+
+	want := outcomes(false)
+	got := outcomes(true)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("outcomes with dry runs = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestGatewayTimeoutPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"fail", "failed"},
+		{"defer", "deferred"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			r := newTestRouter(t)
+			newOrderService(t, ordersFound)
+			setVar[PaymentGateway](t, &gateway, timeoutGateway{})
+			setVar(t, &gatewayTimeout, 10*time.Millisecond)
+			setVar(t, &gatewayTimeoutPolicy, tt.policy)
+
+			payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+			w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process", "", "If-Match", "*")
+			if w.Code != http.StatusOK || decodeJSON[Payment](t, w).Status != tt.want {
+				t.Fatalf("process on a hung gateway = %d %s, want %s", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+// A deferred payment can be processed again once the gateway answers.
+func TestDeferredPaymentIsProcessedLater(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &gatewayTimeout, 10*time.Millisecond)
+	setVar(t, &gatewayTimeoutPolicy, "defer")
+	setVar[PaymentGateway](t, &gateway, timeoutGateway{})
+
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process", "", "If-Match", "*")
+	gateway = thresholdGateway{}
+	mustProcessPayment(t, r, payment.ID)
+}
+
+func TestFailureThreshold(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &paymentFailureThreshold, 50.0)
+
+	tests := []struct {
+		amount float64
+		want   string
+	}{
+		{40, "completed"},
+		{50, "completed"},
+		{50.01, "failed"},
+	}
+	for _, tt := range tests {
+		payment := mustCreatePayment(t, r, uuid.NewString(), tt.amount, "pix")
+		w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process", "", "If-Match", "*")
+		if got := decodeJSON[Payment](t, w).Status; got != tt.want {
+			t.Errorf("payment of %v with a threshold of 50 is %s, want %s", tt.amount, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"payment-service/paymentpb"
+)
+
+// Port the gRPC API listens on (GRPC_PORT); empty disables it
+var grpcPort = ""
+
+// paymentGRPCServer serves paymentpb.PaymentService from the same store,
+// locks and business rules as the REST handlers.
+type paymentGRPCServer struct {
+	paymentpb.UnimplementedPaymentServiceServer
+}
+
+// newGRPCServer builds the gRPC server, with the REST API's TLS settings
+// when TLS is enabled.
+func newGRPCServer() (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if tlsEnabled() {
+		cert, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS key pair: %w", err)
+		}
+		config := buildTLSConfig()
+		config.Certificates = []tls.Certificate{cert}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	opts = append(opts, grpc.UnaryInterceptor(correlationUnaryInterceptor))
+	server := grpc.NewServer(opts...)
+	paymentpb.RegisterPaymentServiceServer(server, &paymentGRPCServer{})
+	return server, nil
+}
+
+// stopGRPCServer lets in-flight calls finish until ctx is done, then
+// closes the remaining connections.
+func stopGRPCServer(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
+
+// grpcError turns the status and body a REST handler would answer with
+// into the matching gRPC status.
+func grpcError(httpStatus int, body gin.H) error {
+	message, _ := body["error"].(string)
+	code := codes.Unknown
+	switch httpStatus {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.FailedPrecondition
+	case http.StatusPreconditionFailed:
+		code = codes.Aborted
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		code = codes.Unavailable
+	case http.StatusInternalServerError:
+		code = codes.Internal
+	}
+	return status.Error(code, message)
+}
+
+// toProtoPayment copies a payment under the read lock.
+func toProtoPayment(payment *Payment) *paymentpb.Payment {
+	paymentsMutex.RLock()
+	defer paymentsMutex.RUnlock()
+	return &paymentpb.Payment{
+		Id:           payment.ID,
+		OrderId:      payment.OrderID,
+		Amount:       float64(payment.Amount),
+		Currency:     payment.Currency,
+		Status:       payment.Status,
+		Method:       payment.Method,
+		CreatedAt:    payment.CreatedAt.Format(time.RFC3339Nano),
+		ProcessedAt:  formatOptionalTime(payment.ProcessedAt),
+		CancelledAt:  formatOptionalTime(payment.CancelledAt),
+		ExpiredAt:    formatOptionalTime(payment.ExpiredAt),
+		BatchId:      payment.BatchID,
+		Version:      int32(payment.Version),
+		Metadata:     maps.Clone(payment.Metadata),
+		CancelReason: payment.CancelReason,
+		RefundAmount: float64(payment.RefundAmount),
+		RefundedAt:   formatOptionalTime(payment.RefundedAt),
+	}
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// CreatePayment mirrors POST /payments. Test-mode headers and
+// Idempotency-Key have no gRPC equivalent.
+func (s *paymentGRPCServer) CreatePayment(ctx context.Context, in *paymentpb.CreatePaymentRequest) (*paymentpb.Payment, error) {
+	if draining.Load() {
+		return nil, status.Error(codes.Unavailable, "Instance is draining")
+	}
+	if shouldShedLoad() {
+		return nil, status.Error(codes.Unavailable, "Payment service is over capacity, retry later")
+	}
+
+	req := CreatePaymentRequest{
+		OrderID:  in.GetOrderId(),
+		Amount:   Amount(in.GetAmount()),
+		Method:   in.GetMethod(),
+		Currency: in.GetCurrency(),
+		BatchID:  in.GetBatchId(),
+		Metadata: in.GetMetadata(),
+	}
+	if req.Currency == "" {
+		req.Currency = defaultCurrency
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	payment, _, rejected := createPayment(ctx, req, false)
+	if rejected != nil {
+		return nil, grpcError(rejected.status, rejected.body)
+	}
+	return toProtoPayment(payment), nil
+}
+
+// GetPayment mirrors GET /payments/:payment_id.
+func (s *paymentGRPCServer) GetPayment(ctx context.Context, in *paymentpb.GetPaymentRequest) (*paymentpb.Payment, error) {
+	if !isValidPaymentID(in.GetPaymentId()) {
+		return nil, status.Error(codes.InvalidArgument, "Malformed payment ID")
+	}
+	paymentsMutex.RLock()
+	payment, exists := payments.Get(in.GetPaymentId())
+	paymentsMutex.RUnlock()
+	if !exists {
+		return nil, status.Error(codes.NotFound, "Payment not found")
+	}
+	return toProtoPayment(payment), nil
+}
+
+// ProcessPayment mirrors POST /payments/:payment_id/process. It always
+// settles the payment within the call, whatever PROCESS_MODE says.
+func (s *paymentGRPCServer) ProcessPayment(ctx context.Context, in *paymentpb.ProcessPaymentRequest) (*paymentpb.Payment, error) {
+	if !isValidPaymentID(in.GetPaymentId()) {
+		return nil, status.Error(codes.InvalidArgument, "Malformed payment ID")
+	}
+	expected := anyVersion
+	if in.GetExpectedVersion() > 0 {
+		expected = int(in.GetExpectedVersion())
+	}
+
+	paymentsMutex.RLock()
+	payment, exists := payments.Get(in.GetPaymentId())
+	paymentsMutex.RUnlock()
+	if !exists {
+		return nil, status.Error(codes.NotFound, "Payment not found")
+	}
+
+	if err := processPayment(ctx, payment, "", expected); err != nil {
+		switch {
+		case errors.Is(err, errVersionMismatch):
+			return nil, status.Error(codes.Aborted, err.Error())
+		case errors.Is(err, errNotProcessable):
+			paymentsMutex.RLock()
+			current := payment.Status
+			paymentsMutex.RUnlock()
+			return nil, status.Errorf(codes.FailedPrecondition, "Payment cannot be processed in status %s", current)
+		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			return nil, status.Error(codes.Unavailable, "Payment processing was interrupted; payment left unchanged")
+		default:
+			return nil, status.Error(codes.Unavailable, "Payment gateway error")
+		}
+	}
+	return toProtoPayment(payment), nil
+}
+
+// ListPayments mirrors GET /payments. Every payment is listed; the
+// LIST_MAX_AGE default window only applies to REST callers.
+func (s *paymentGRPCServer) ListPayments(ctx context.Context, in *paymentpb.ListPaymentsRequest) (*paymentpb.ListPaymentsResponse, error) {
+	statuses, err := parseStatusFilter(strings.Join(in.GetStatuses(), ","))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if orderID := in.GetOrderId(); orderID != "" && !isValidOrderID(orderID) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid order ID")
+	}
+	limit, offset := int(in.GetLimit()), int(in.GetOffset())
+	if limit == 0 {
+		limit = defaultPageLimit
+	}
+	if limit < 0 || limit > maxPageLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 0 and %d", maxPageLimit)
+	}
+	if offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset must be non-negative")
+	}
+
+	paymentList := listPayments(in.GetOrderId(), statuses, time.Time{})
+	total := len(paymentList)
+	start := min(offset, total)
+	resp := &paymentpb.ListPaymentsResponse{Total: int32(total)}
+	for i := start; i < min(start+limit, total); i++ {
+		resp.Payments = append(resp.Payments, toProtoPayment(&paymentList[i]))
+	}
+	return resp, nil
+}
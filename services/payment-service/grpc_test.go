@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"payment-service/paymentpb"
+)
+
+// newGRPCClient serves the gRPC API on a loopback port for the test.
+func newGRPCClient(t *testing.T) paymentpb.PaymentServiceClient {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newGRPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return paymentpb.NewPaymentServiceClient(conn)
+}
+
+func TestGRPCPaymentLifecycle(t *testing.T) {
+	newTestRouter(t)
+	missingOrder := uuid.NewString()
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		if path.Base(req.URL.Path) == missingOrder {
+			ordersMissing(w, req)
+			return
+		}
+		ordersFound(w, req)
+	})
+	client := newGRPCClient(t)
+	ctx := context.Background()
+	orderID := uuid.NewString()
+
+	created, err := client.CreatePayment(ctx, &paymentpb.CreatePaymentRequest{
+		OrderId: orderID, Amount: 10, Method: "pix", Metadata: map[string]string{"channel": "grpc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.GetStatus() != "pending" || created.GetOrderId() != orderID || created.GetCurrency() != "USD" ||
+		created.GetMetadata()["channel"] != "grpc" {
+		t.Fatalf("created over gRPC = %v, want a pending USD payment for the order with its metadata", created)
+	}
+	processed, err := client.ProcessPayment(ctx, &paymentpb.ProcessPaymentRequest{PaymentId: created.GetId(), ExpectedVersion: created.GetVersion()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed.GetStatus() != "completed" {
+		t.Fatalf("processed over gRPC = %v, want completed", processed)
+	}
+	list, err := client.ListPayments(ctx, &paymentpb.ListPaymentsRequest{OrderId: orderID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.GetPayments()) != 1 || list.GetPayments()[0].GetId() != created.GetId() {
+		t.Fatalf("order payments over gRPC = %v, want the created one", list.GetPayments())
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{"create for a missing order", func() error {
+			_, err := client.CreatePayment(ctx, &paymentpb.CreatePaymentRequest{OrderId: missingOrder, Amount: 10, Method: "pix"})
+			return err
+		}, codes.InvalidArgument},
+		{"create with an unknown method", func() error {
+			_, err := client.CreatePayment(ctx, &paymentpb.CreatePaymentRequest{OrderId: orderID, Amount: 10, Method: "banana"})
+			return err
+		}, codes.InvalidArgument},
+		{"create with too much metadata", func() error {
+			metadata := make(map[string]string)
+			for i := 0; i <= maxMetadataKeys; i++ {
+				metadata[fmt.Sprint("key", i)] = "value"
+			}
+			_, err := client.CreatePayment(ctx, &paymentpb.CreatePaymentRequest{OrderId: orderID, Amount: 10, Method: "pix", Metadata: metadata})
+			return err
+		}, codes.InvalidArgument},
+		{"get an unknown payment", func() error {
+			_, err := client.GetPayment(ctx, &paymentpb.GetPaymentRequest{PaymentId: uuid.NewString()})
+			return err
+		}, codes.NotFound},
+		{"get a malformed ID", func() error {
+			_, err := client.GetPayment(ctx, &paymentpb.GetPaymentRequest{PaymentId: "nope"})
+			return err
+		}, codes.InvalidArgument},
+		{"reprocess a completed payment", func() error {
+			_, err := client.ProcessPayment(ctx, &paymentpb.ProcessPaymentRequest{PaymentId: created.GetId()})
+			return err
+		}, codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		if got := status.Code(tt.call()); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGRPCPaymentCarriesMetadataCancelAndRefund(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	client := newGRPCClient(t)
+	ctx := context.Background()
+
+	body := fmt.Sprintf(`{"order_id":%q,"amount":10,"method":"pix","metadata":{"scenario":"refund"}}`, uuid.NewString())
+	w := doRequest(t, r, http.MethodPost, "/payments", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s, want 201", w.Code, w.Body.String())
+	}
+	refunded := decodeJSON[Payment](t, w)
+	mustProcessPayment(t, r, refunded.ID)
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+refunded.ID+"/refund", `{"amount":4}`, "If-Match", "*"); w.Code != http.StatusOK {
+		t.Fatalf("refund = %d %s", w.Code, w.Body.String())
+	}
+
+	got, err := client.GetPayment(ctx, &paymentpb.GetPaymentRequest{PaymentId: refunded.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetMetadata()["scenario"] != "refund" || got.GetRefundAmount() != 4 || got.GetRefundedAt() == "" {
+		t.Fatalf("gRPC payment = %v, want its metadata, refund amount and refund time", got)
+	}
+
+	cancelled := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+cancelled.ID+"/cancel", `{"reason":"duplicate"}`); w.Code != http.StatusOK {
+		t.Fatalf("cancel = %d %s", w.Code, w.Body.String())
+	}
+	list, err := client.ListPayments(ctx, &paymentpb.ListPaymentsRequest{Statuses: []string{"cancelled"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.GetPayments()) != 1 || list.GetPayments()[0].GetCancelReason() != "duplicate" {
+		t.Fatalf("cancelled payments over gRPC = %v, want one with its reason", list.GetPayments())
+	}
+}
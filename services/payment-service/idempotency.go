@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// How long an Idempotency-Key maps to the payment it created
+	idempotencyTTL = 24 * time.Hour
+	// Keys seen on POST /payments and refunds, scoped by operation, with the
+	// request they were first used for
+	idempotencyKeys      = make(map[string]*idempotencyEntry)
+	idempotencyMutex     = sync.Mutex{}
+	lastIdempotencyPrune = time.Now()
+)
+
+var errIdempotencyMismatch = errors.New("Idempotency-Key was already used with a different request")
+
+// Operations an Idempotency-Key is scoped to, so a client reusing its key
+// for a refund doesn't get back the payment the key created.
+const (
+	idempotencyCreate = "create"
+	idempotencyRefund = "refund"
+)
+
+// idempotencyScope is the idempotencyKeys entry for key within operation.
+func idempotencyScope(operation, key string) string {
+	return operation + ":" + key
+}
+
+// idempotencyEntry tracks one key. done is closed once the request that
+// claimed the key has finished; paymentID is empty if it created nothing.
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	paymentID   string
+	createdAt   time.Time
+	done        chan struct{}
+}
+
+// isValidIdempotencyKey accepts 1-128 printable ASCII characters.
+func isValidIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > 128 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestFingerprint identifies a request by its decoded content, so
+// retries with different formatting still match.
+func requestFingerprint(req any) [sha256.Size]byte {
+	encoded, _ := json.Marshal(req)
+	return sha256.Sum256(encoded)
+}
+
+// claimIdempotencyKey returns the payment ID an earlier request with this
+// key created, or claims the key for the caller (owner=true), who must
+// later call finishIdempotencyKey. A retry that arrives while the first
+// request is still running waits for it. Reusing a key for a different
+// request returns errIdempotencyMismatch.
+func claimIdempotencyKey(key string, fingerprint [sha256.Size]byte) (entry *idempotencyEntry, owner bool, err error) {
+	for {
+		idempotencyMutex.Lock()
+		current := clock()
+		if current.Sub(lastIdempotencyPrune) > idempotencyTTL {
+			for k, e := range idempotencyKeys {
+				if e.paymentID != "" && current.Sub(e.createdAt) > idempotencyTTL {
+					delete(idempotencyKeys, k)
+				}
+			}
+			lastIdempotencyPrune = current
+		}
+
+		existing, exists := idempotencyKeys[key]
+		if exists && existing.paymentID != "" && current.Sub(existing.createdAt) > idempotencyTTL {
+			exists = false
+		}
+		if !exists {
+			entry = &idempotencyEntry{fingerprint: fingerprint, createdAt: current, done: make(chan struct{})}
+			idempotencyKeys[key] = entry
+			idempotencyMutex.Unlock()
+			return entry, true, nil
+		}
+		if existing.fingerprint != fingerprint {
+			idempotencyMutex.Unlock()
+			return nil, false, errIdempotencyMismatch
+		}
+		if existing.paymentID != "" {
+			idempotencyMutex.Unlock()
+			return existing, false, nil
+		}
+		idempotencyMutex.Unlock()
+
+		// Still in flight: wait, then look again - if it created nothing
+		// the key has been released and this request may claim it
+		<-existing.done
+	}
+}
+
+// finishIdempotencyKey records the payment a claimed key created. With no
+// payment the key is released so a retry can try again.
+func finishIdempotencyKey(key string, entry *idempotencyEntry, paymentID string) {
+	idempotencyMutex.Lock()
+	if paymentID == "" {
+		if idempotencyKeys[key] == entry {
+			delete(idempotencyKeys, key)
+		}
+	} else {
+		entry.paymentID = paymentID
+	}
+	idempotencyMutex.Unlock()
+	close(entry.done)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestIdempotencyKeyReplaysCreation(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+	body := createPaymentBody(uuid.NewString(), 10, "pix")
+	key := []string{"Idempotency-Key", "checkout-42"}
+
+	first := doRequest(t, r, http.MethodPost, "/payments", body, key...)
+	retry := doRequest(t, r, http.MethodPost, "/payments", body, key...)
+	if first.Code != http.StatusCreated || retry.Code != http.StatusOK {
+		t.Fatalf("create then retry = %d, %d; want 201 then 200", first.Code, retry.Code)
+	}
+	created := decodeJSON[Payment](t, first)
+	if replayed := decodeJSON[Payment](t, retry); replayed.ID != created.ID {
+		t.Fatalf("retry returned payment %s, want the original %s", replayed.ID, created.ID)
+	}
+	if page := decodeJSON[paymentPage](t, doRequest(t, r, http.MethodGet, "/payments", "")); page.Total != 1 {
+		t.Fatalf("%d payments stored, want 1", page.Total)
+	}
+
+	other := createPaymentBody(uuid.NewString(), 10, "pix")
+	if w := doRequest(t, r, http.MethodPost, "/payments", other, key...); w.Code != http.StatusConflict {
+		t.Fatalf("key reused for another request = %d, want 409", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodPost, "/payments", body, "Idempotency-Key", "bad key"); w.Code != http.StatusBadRequest {
+		t.Fatalf("malformed key = %d, want 400", w.Code)
+	}
+
+	advance(idempotencyTTL + time.Minute)
+	if w := doRequest(t, r, http.MethodPost, "/payments", body, key...); w.Code != http.StatusCreated {
+		t.Fatalf("key reused after its TTL = %d, want a new payment", w.Code)
+	}
+}
+
+// A key whose request created nothing is released for the retry.
+func TestIdempotencyKeyReleasedOnFailure(t *testing.T) {
+	r := newTestRouter(t)
+	var warmingUp atomic.Bool
+	warmingUp.Store(true)
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		if warmingUp.Load() {
+			http.Error(w, `{"error":"warming up"}`, http.StatusServiceUnavailable)
+			return
+		}
+		ordersFound(w, req)
+	})
+	setVar(t, &validationMaxAttempts, 1)
+	body := createPaymentBody(uuid.NewString(), 10, "pix")
+	key := []string{"Idempotency-Key", "checkout-43"}
+
+	if w := doRequest(t, r, http.MethodPost, "/payments", body, key...); w.Code == http.StatusCreated {
+		t.Fatalf("create while the order-service fails = %d, want an error", w.Code)
+	}
+	warmingUp.Store(false)
+	if w := doRequest(t, r, http.MethodPost, "/payments", body, key...); w.Code != http.StatusCreated {
+		t.Fatalf("retry after the failure = %d %s, want 201", w.Code, w.Body.String())
+	}
+}
+
+// Keys are scoped by operation: the key that created a payment can be
+// reused to refund it, and refund retries don't refund twice.
+func TestIdempotencyKeyScopedByOperation(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	key := []string{"Idempotency-Key", "checkout-42"}
+
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"), key...)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s, want 201", w.Code, w.Body.String())
+	}
+	payment := mustProcessPayment(t, r, decodeJSON[Payment](t, w).ID)
+	refund := "/payments/" + payment.ID + "/refund"
+	refundKey := append([]string{"If-Match", "*"}, key...)
+
+	w = doRequest(t, r, http.MethodPost, refund, `{"amount":3}`, refundKey...)
+	if got := decodeJSON[Payment](t, w); w.Code != http.StatusOK || got.RefundAmount != 3 {
+		t.Fatalf("refund with the creation's key = %d %s, want 3 refunded", w.Code, w.Body.String())
+	}
+	w = doRequest(t, r, http.MethodPost, refund, `{"amount":3}`, refundKey...)
+	if got := decodeJSON[Payment](t, w); w.Code != http.StatusOK || got.RefundAmount != 3 {
+		t.Fatalf("refund retry = %d %s, want the refund replayed, not repeated", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodPost, refund, `{"amount":4}`, refundKey...); w.Code != http.StatusConflict {
+		t.Fatalf("refund key reused for another amount = %d, want 409", w.Code)
+	}
+
+	other := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+other.ID+"/refund", "", "Idempotency-Key", "refund-7", "If-Match", "*"); w.Code != http.StatusConflict {
+		t.Fatalf("refund of a pending payment = %d, want 409", w.Code)
+	}
+	mustProcessPayment(t, r, other.ID)
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+other.ID+"/refund", "", "Idempotency-Key", "refund-7", "If-Match", "*"); w.Code != http.StatusOK {
+		t.Fatalf("refund retried after a rejection = %d, want the key released and 200", w.Code)
+	}
+}
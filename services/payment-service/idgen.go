@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator hands out payment IDs. IDs must pass isValidPaymentID.
+type IDGenerator interface {
+	NewID() string
+}
+
+// uuidGenerator issues random UUIDs.
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewID() string {
+	return uuid.New().String()
+}
+
+// sequentialGenerator issues 00000000-0000-4000-8000-000000000001,
+// ...0002 and so on: predictable, yet still valid UUIDs. The count starts
+// over on restart, so it suits the memory store, not PAYMENT_STORE=file.
+type sequentialGenerator struct {
+	last atomic.Uint64
+}
+
+func (g *sequentialGenerator) NewID() string {
+	return fmt.Sprintf("00000000-0000-4000-8000-%012x", g.last.Add(1))
+}
+
+// seededGenerator issues random-looking UUIDs drawn from a fixed seed, so
+// the same sequence of creations yields the same IDs on every run. Like
+// sequentialGenerator it repeats itself after a restart.
+type seededGenerator struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newSeededGenerator(seed int64) *seededGenerator {
+	return &seededGenerator{rand: rand.New(rand.NewSource(seed))}
+}
+
+func (g *seededGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id, _ := uuid.NewRandomFromReader(g.rand)
+	return id.String()
+}
+
+var (
+	idGenerator IDGenerator = uuidGenerator{}
+	// "uuid", "sequential" or "seeded" (PAYMENT_ID_GENERATOR)
+	idGeneratorKind = "uuid"
+	// Seed for the seeded generator (PAYMENT_ID_SEED)
+	idGeneratorSeed int64 = 1
+)
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSequentialPaymentIDs(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar[IDGenerator](t, &idGenerator, &sequentialGenerator{})
+
+	for _, want := range []string{"00000000-0000-4000-8000-000000000001", "00000000-0000-4000-8000-000000000002"} {
+		payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+		if payment.ID != want {
+			t.Fatalf("payment ID = %s, want %s", payment.ID, want)
+		}
+		if w := doRequest(t, r, http.MethodGet, "/payments/"+want, ""); w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, want 200", want, w.Code)
+		}
+	}
+}
+
+// The same seed yields the same IDs, in the same order, on every run.
+func TestSeededPaymentIDs(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+
+	run := func(seed int64) []string {
+		idGenerator = newSeededGenerator(seed)
+		resetState()
+		ids := make([]string, 3)
+		for i := range ids {
+			ids[i] = mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID
+		}
+		return ids
+	}
+	setVar[IDGenerator](t, &idGenerator, nil)
+	first, again, other := run(7), run(7), run(8)
+	for i := range first {
+		if first[i] != again[i] {
+			t.Fatalf("seed 7 gave %v, then %v", first, again)
+		}
+		if first[i] == other[i] {
+			t.Fatalf("seeds 7 and 8 both gave %s", first[i])
+		}
+		if !isValidPaymentID(first[i]) {
+			t.Fatalf("seeded ID %q is not a valid payment ID", first[i])
+		}
+	}
+}
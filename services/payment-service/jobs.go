@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ProcessingJob tracks a payment processed asynchronously by the worker pool.
+type ProcessingJob struct {
+	ID          string     `json:"id"`
+	PaymentID   string     `json:"payment_id"`
+	Status      string     `json:"status"` // queued, running, succeeded, failed
+	Error       string     `json:"error,omitempty"`
+	Payment     *Payment   `json:"payment,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	payment *Payment
+	forced  string
+	// Version the client expected via If-Match, checked when claimed
+	version int
+}
+
+var (
+	// "sync" processes in the request, "queue" hands work to the worker pool
+	processMode       = "sync"
+	processWorkers    = 4
+	processQueueSize  = 100
+	processQueue      chan *ProcessingJob
+	processQueueOnce  sync.Once
+	processingJobs    = make(map[string]*ProcessingJob)
+	processingJobsMux = sync.RWMutex{}
+	// How long a finished job can still be looked up (PROCESS_JOB_TTL)
+	processJobTTL = 15 * time.Minute
+	lastJobPrune  = time.Now()
+)
+
+var errQueueFull = errors.New("processing queue is full, retry later")
+
+func jobQueue() chan *ProcessingJob {
+	processQueueOnce.Do(func() {
+		processQueue = make(chan *ProcessingJob, processQueueSize)
+	})
+	return processQueue
+}
+
+// enqueueProcessing records a job for the payment and queues it without
+// blocking; a full queue is reported so the client can back off.
+func enqueueProcessing(payment *Payment, forced string, expected int) (*ProcessingJob, error) {
+	job := &ProcessingJob{
+		ID:        uuid.New().String(),
+		PaymentID: payment.ID,
+		Status:    "queued",
+		CreatedAt: clock(),
+		payment:   payment,
+		forced:    forced,
+		version:   expected,
+	}
+
+	processingJobsMux.Lock()
+	pruneProcessingJobs(job.CreatedAt)
+	processingJobs[job.ID] = job
+	processingJobsMux.Unlock()
+
+	select {
+	case jobQueue() <- job:
+		return snapshotJob(job), nil
+	default:
+		processingJobsMux.Lock()
+		delete(processingJobs, job.ID)
+		processingJobsMux.Unlock()
+		return nil, errQueueFull
+	}
+}
+
+// snapshotJob copies a job under the read lock so it can be serialised
+// while workers keep updating the original.
+func snapshotJob(job *ProcessingJob) *ProcessingJob {
+	processingJobsMux.RLock()
+	defer processingJobsMux.RUnlock()
+	snapshot := *job
+	return &snapshot
+}
+
+// pruneProcessingJobs forgets jobs that finished more than processJobTTL
+// ago, at most once per TTL. The caller must hold processingJobsMux for
+// writing.
+func pruneProcessingJobs(now time.Time) {
+	if now.Sub(lastJobPrune) <= processJobTTL {
+		return
+	}
+	for id, job := range processingJobs {
+		if jobExpired(job, now) {
+			delete(processingJobs, id)
+		}
+	}
+	lastJobPrune = now
+}
+
+// jobExpired reports whether a job finished more than processJobTTL ago.
+// The caller must hold processingJobsMux.
+func jobExpired(job *ProcessingJob, now time.Time) bool {
+	return job.CompletedAt != nil && now.Sub(*job.CompletedAt) > processJobTTL
+}
+
+// setJobState records a job's progress. result is the processed payment,
+// copied by the caller so the job never shares it with later updates.
+func setJobState(job *ProcessingJob, status string, err error, result *Payment) {
+	processingJobsMux.Lock()
+	defer processingJobsMux.Unlock()
+	job.Status = status
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if status == "succeeded" || status == "failed" {
+		completed := clock()
+		job.CompletedAt = &completed
+	}
+	job.Payment = result
+}
+
+// startProcessWorkers runs the worker pool until ctx is cancelled.
+func startProcessWorkers(ctx context.Context) *sync.WaitGroup {
+	queue := jobQueue()
+	var wg sync.WaitGroup
+	for i := 0; i < processWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-queue:
+					runProcessingJob(ctx, job)
+				}
+			}
+		}()
+	}
+	return &wg
+}
+
+func runProcessingJob(ctx context.Context, job *ProcessingJob) {
+	setJobState(job, "running", nil, nil)
+
+	// The payment may have changed state while the job was queued
+	paymentsMutex.RLock()
+	status := job.payment.Status
+	paymentsMutex.RUnlock()
+	if !isProcessable(status) {
+		setJobState(job, "failed", fmt.Errorf("payment cannot be processed in status %s", status), nil)
+		return
+	}
+
+	if err := processPayment(ctx, job.payment, job.forced, job.version); err != nil {
+		setJobState(job, "failed", err, nil)
+		return
+	}
+	paymentsMutex.RLock()
+	result := *job.payment
+	paymentsMutex.RUnlock()
+	setJobState(job, "succeeded", nil, &result)
+}
+
+// getProcessingJob reports the progress and result of a queued job. Jobs
+// that finished more than processJobTTL ago are gone.
+func getProcessingJob(c *gin.Context) {
+	processingJobsMux.RLock()
+	job, exists := processingJobs[c.Param("job_id")]
+	if exists && jobExpired(job, clock()) {
+		exists = false
+	}
+	processingJobsMux.RUnlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, snapshotJob(job))
+}
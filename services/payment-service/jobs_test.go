@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// startTestWorkers runs the processing worker pool until the test ends.
+func startTestWorkers(t *testing.T) {
+	t.Helper()
+	setVar(t, &processMode, "queue")
+	ctx, cancel := context.WithCancel(context.Background())
+	workers := startProcessWorkers(ctx)
+	t.Cleanup(func() {
+		cancel()
+		workers.Wait()
+	})
+}
+
+// waitForJob polls GET /jobs/:job_id until the job has finished.
+func waitForJob(t *testing.T, handler http.Handler, jobID string) ProcessingJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		w := doRequest(t, handler, http.MethodGet, "/jobs/"+jobID, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /jobs/%s = %d %s", jobID, w.Code, w.Body.String())
+		}
+		job := decodeJSON[ProcessingJob](t, w)
+		if job.Status == "succeeded" || job.Status == "failed" {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", jobID)
+	return ProcessingJob{}
+}
+
+// queueProcessing processes a payment in queue mode and returns its job.
+func queueProcessing(t *testing.T, handler http.Handler, paymentID string) ProcessingJob {
+	t.Helper()
+	w := doRequest(t, handler, http.MethodPost, "/payments/"+paymentID+"/process", "", "If-Match", "*")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("queue processing = %d %s, want 202", w.Code, w.Body.String())
+	}
+	return decodeJSON[ProcessingJob](t, w)
+}
+
+func TestJobKeepsTheProcessedPayment(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	startTestWorkers(t)
+
+	payment := mustCreatePayment(t, r, uuid.NewString(), 50, "pix")
+	job := waitForJob(t, r, queueProcessing(t, r, payment.ID).ID)
+	if job.Status != "succeeded" || job.Payment == nil || job.Payment.Status != "completed" {
+		t.Fatalf("job = %+v, want it succeeded with the completed payment", job)
+	}
+
+	// Later changes to the payment don't rewrite the job's result
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/refund", "", "If-Match", "*"); w.Code != http.StatusOK {
+		t.Fatalf("refund = %d %s", w.Code, w.Body.String())
+	}
+	again := waitForJob(t, r, job.ID)
+	if again.Payment.Status != "completed" || again.Payment.Version != job.Payment.Version {
+		t.Fatalf("job payment after refund = %+v, want the payment as processed", again.Payment)
+	}
+}
+
+func TestFinishedJobsExpire(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	startTestWorkers(t)
+	advance := useFakeClock(t)
+	setVar(t, &processJobTTL, time.Minute)
+	setVar(t, &lastJobPrune, clock())
+
+	first := mustCreatePayment(t, r, uuid.NewString(), 50, "pix")
+	job := waitForJob(t, r, queueProcessing(t, r, first.ID).ID)
+
+	advance(2 * time.Minute)
+	if w := doRequest(t, r, http.MethodGet, "/jobs/"+job.ID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET expired job = %d %s, want 404", w.Code, w.Body.String())
+	}
+
+	// The next job sweeps the expired one away
+	second := mustCreatePayment(t, r, uuid.NewString(), 50, "pix")
+	waitForJob(t, r, queueProcessing(t, r, second.ID).ID)
+	processingJobsMux.RLock()
+	_, kept := processingJobs[job.ID]
+	remaining := len(processingJobs)
+	processingJobsMux.RUnlock()
+	if kept || remaining != 1 {
+		t.Fatalf("jobs after sweep = %d (expired kept: %v), want only the new one", remaining, kept)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// logLevel is the minimum level logged (LOG_LEVEL: debug, info, warn, error).
+var logLevel = new(slog.LevelVar)
+
+// logger writes JSON lines tagged with the service name, and with the
+// correlation ID when logged with a request context.
+var logger = slog.New(correlationHandler{slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})}).
+	With("service", "payment-service")
+
+// tokenPrefix shortens a secret for logging so the full value never
+// reaches the logs.
+func tokenPrefix(token string) string {
+	if len(token) <= 4 {
+		return ""
+	}
+	return token[:4] + "..."
+}
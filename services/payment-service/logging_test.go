@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// logEntries decodes the JSON log lines written so far.
+func logEntries(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// findLog returns the first entry with the given message, or nil.
+func findLog(entries []map[string]any, msg string) map[string]any {
+	for _, entry := range entries {
+		if entry["msg"] == msg {
+			return entry
+		}
+	}
+	return nil
+}
+
+func TestRejectedCSRFTokenIsLoggedWithoutTheToken(t *testing.T) {
+	r := newTestRouter(t)
+	setVar(t, &csrfEnforce, true)
+	logs := captureLogs(t)
+	token := "forged-token-value"
+
+	doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"), "X-CSRF-Token", token)
+	entry := findLog(logEntries(t, logs), "csrf token rejected")
+	if entry == nil || entry["level"] != "WARN" || entry["path"] != "/payments" || entry["token_prefix"] != "forg..." {
+		t.Fatalf("logs = %s, want a csrf token rejected warning with the token prefix", logs.String())
+	}
+	if strings.Contains(logs.String(), token) {
+		t.Fatalf("logs = %s, want the full token left out", logs.String())
+	}
+}
+
+func TestFailedValidationAttemptIsLogged(t *testing.T) {
+	r := newTestRouter(t)
+	setVar(t, &validationMaxAttempts, 1)
+	orders := newOrderService(t, ordersFound)
+	orders.Close() // refuse connections
+	logs := captureLogs(t)
+	orderID := uuid.NewString()
+
+	doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(orderID, 10, "pix"))
+	entry := findLog(logEntries(t, logs), "order validation attempt failed")
+	if entry == nil || entry["level"] != "WARN" || entry["order_id"] != orderID || entry["attempt"] != 1.0 || entry["error"] == nil {
+		t.Fatalf("logs = %s, want a warning naming the order, attempt and error", logs.String())
+	}
+}
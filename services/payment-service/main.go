@@ -1,111 +1,449 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"regexp"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc"
 )
 
 type Payment struct {
 	ID          string    `json:"id"`
 	OrderID     string    `json:"order_id"`
-	Amount      float64   `json:"amount"`
+	Amount      Amount    `json:"amount"`
+	Currency    string    `json:"currency"`
+	FormattedAmount string `json:"formatted_amount"`
 	Status      string    `json:"status"`
 	Method      string    `json:"method"`
 	CreatedAt   time.Time `json:"created_at"`
 	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+	// Time between creation and processing, only set once processed
+	ProcessingLatencyMs *int64 `json:"processing_latency_ms,omitempty"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+	// Why the payment was cancelled, when the canceller said
+	CancelReason string    `json:"cancel_reason,omitempty"`
+	// Set when a payment left pending for too long is expired
+	ExpiredAt   *time.Time `json:"expired_at,omitempty"`
+	// Time of the latest refund and the total refunded so far
+	RefundedAt   *time.Time `json:"refunded_at,omitempty"`
+	RefundAmount Amount     `json:"refund_amount,omitempty"`
+	BatchID     string     `json:"batch_id,omitempty"`
+	// Bumped by every change; send it back in If-Match to update safely
+	Version     int        `json:"version"`
+	// Annotations supplied at creation, returned unchanged
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// Outcome dictated by the order-service, honoured over the gateway
+	orderOutcome string
 }
 
 type CreatePaymentRequest struct {
 	OrderID string  `json:"order_id" binding:"required"`
-	Amount  float64 `json:"amount" binding:"required"`
+	Amount  Amount  `json:"amount"` // checked by validateAmount
 	Method  string  `json:"method" binding:"required"`
+	// ISO 4217 code; USD when omitted
+	Currency string `json:"currency"`
+	BatchID string  `json:"batch_id"`
+	// Free-form test annotations, such as a scenario name or run ID
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
+// CancelRequest is the optional body of POST /payments/:payment_id/cancel.
+type CancelRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Longest cancellation reason accepted
+const maxCancelReasonLength = 200
+
 var (
-	payments = make(map[string]*Payment)
+	// Simulated time spent processing a payment (PROCESS_DELAY)
+	processingDelay time.Duration = 0
+	// Simulated time before a ?async=true payment is settled (ASYNC_PROCESS_DELAY)
+	asyncProcessDelay = 2 * time.Second
+	// Payments being settled in the background, waited for on shutdown
+	asyncProcessing sync.WaitGroup
+	// Upper bound on one order validation across all attempts and backoff
+	// (VALIDATION_TOTAL_TIMEOUT)
+	validationTotalTimeout = 3 * time.Second
+	// How long in-flight requests get to finish on shutdown
+	shutdownTimeout = 10 * time.Second
+	// GET /payments omits payments older than this unless include_all=true (0 disables)
+	listMaxAge time.Duration = 0
+	payments PaymentStore = newMemoryStore()
 	paymentsMutex = sync.RWMutex{}
+	// Order-service hosts validation may call, as host:port (ALLOWED_ORDER_HOSTS)
 	allowedHosts = []string{"localhost:8002", "order-service:8002"}
+	// Base URL order validation calls (ORDER_SERVICE_URL); its host must be allowed
+	orderServiceURL = "http://localhost:8002"
 	// Cache for order validation to improve performance
-	orderValidationCache = make(map[string]bool)
+	orderValidationCache = make(map[string]orderInfo)
 	cacheMutex = sync.RWMutex{}
 	cacheExpiry = 30 * time.Second
 	lastCacheClean = time.Now()
+	// In-flight validations so concurrent lookups for one order share a call
+	inflightValidations singleflight.Group
+)
+
+var (
+	errValidationDeadline = errors.New("order validation deadline exceeded")
+	errValidationCancelled = errors.New("order validation cancelled by the caller")
+	errNotProcessable     = errors.New("payment cannot be processed in its current status")
+	errOrderRateLimited   = errors.New("order-service kept rate limiting validation")
 )
 
+// orderInfo is what the validation cache keeps about an order, so later
+// checks (amount, currency) can reuse it without another call.
+type orderInfo struct {
+	Valid       bool
+	TotalAmount float64
+	Currency    string
+	Status      string
+	UserID      string
+	// Intended processing outcome for scenario testing ("completed"/"failed")
+	PaymentOutcome string
+	// When the entry was stored in the cache
+	CachedAt time.Time
+}
+
+// validationTrace records how an order-validation decision was reached.
+type validationTrace struct {
+	FormatValid bool   `json:"format_valid"`
+	CacheHit    bool   `json:"cache_hit"`
+	Coalesced   bool   `json:"coalesced"`
+	Allowlisted bool   `json:"allowlisted"`
+	Attempts    int    `json:"attempts"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	Valid       bool   `json:"valid"`
+	// The order-service could not give an answer (unreachable, 5xx or out
+	// of time); the order itself may well be valid
+	Transient bool `json:"transient,omitempty"`
+	// The caller went away before the order-service answered
+	cancelled bool
+}
+
+// giveUp records that validation stopped before the order-service
+// answered, because the caller went away or the deadline passed.
+func (t *validationTrace) giveUp(ctx context.Context) {
+	t.Transient = true
+	if errors.Is(ctx.Err(), context.Canceled) {
+		t.LastError = errValidationCancelled.Error()
+		t.cancelled = true
+		return
+	}
+	t.LastError = errValidationDeadline.Error()
+}
+
 func main() {
+	loadConfig()
+	if err := openPaymentStore(); err != nil {
+		logger.Error("failed to open payment store", "error", err)
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, ":8003"); err != nil {
+		logger.Error("payment service stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run serves the API until ctx is cancelled, then shuts down gracefully:
+// in-flight requests are allowed to finish and idle connections to the
+// order-service are closed so nothing is left running.
+func run(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: setupRouter()}
+	if tlsEnabled() {
+		server.TLSConfig = buildTLSConfig()
+	}
+
+	workerCtx, stopWorkers := context.WithCancel(ctx)
+	workers := startProcessWorkers(workerCtx)
+	sweeper := startExpirySweeper(workerCtx)
+	defer func() {
+		stopWorkers()
+		workers.Wait()
+		sweeper.Wait()
+	}()
+
+	errs := make(chan error, 2)
+	go func() {
+		if tlsEnabled() {
+			errs <- server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	// The gRPC API gets its own port, sharing the store with REST
+	var grpcServer *grpc.Server
+	if grpcPort != "" {
+		listener, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			server.Close()
+			return err
+		}
+		if grpcServer, err = newGRPCServer(); err != nil {
+			listener.Close()
+			server.Close()
+			return err
+		}
+		go func() { errs <- grpcServer.Serve(listener) }()
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if grpcServer != nil {
+		stopGRPCServer(shutdownCtx, grpcServer)
+	}
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		// Out of time - closing connections cancels the remaining requests,
+		// which puts any payment they were processing back as it was
+		server.Close()
+	}
+	// Background settlements may still send webhooks
+	asyncProcessing.Wait()
+	webhookDeliveries.Wait()
+	httpClient.CloseIdleConnections()
+	webhookClient.CloseIdleConnections()
+	return err
+}
+
+// setupRouter builds the gin engine with all middleware and routes.
+func setupRouter() *gin.Engine {
 	r := gin.Default()
+	
+	// Client IPs come from X-Forwarded-For only when a trusted proxy sent it
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		logger.Error("ignoring trusted proxies", "proxies", trustedProxies, "error", err.Error())
+		r.SetTrustedProxies(nil)
+	}
+
+	// Correlation ID for stitching logs together across services
+	r.Use(correlationMiddleware())
+
+	// One span per request, continuing any incoming trace
+	r.Use(tracingMiddleware())
+
+	// Per-client rate limit on write requests
+	r.Use(rateLimitMiddleware())
+
+	// Chaos testing: injected latency and errors on payment writes
+	r.Use(faultInjectionMiddleware())
 
 	// CSRF middleware
 	r.Use(csrfMiddleware())
 
+	// Mutating requests must be JSON
+	r.Use(contentTypeMiddleware())
+
+	// Replay protection for mutating requests
+	r.Use(nonceMiddleware())
+
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
-			"status":  "healthy",
-			"service": "payment-service",
+			"status":                "healthy",
+			"service":               "payment-service",
+			"order_service_breaker": orderBreaker.State(),
 		})
 	})
 
+	// Readiness - fails while draining
+	r.GET("/health/ready", readinessCheck)
+
+	// Effective runtime configuration, secrets redacted
+	r.GET("/admin/config", adminMiddleware(), getEffectiveConfig)
+
+	// Take the instance out of rotation and back
+	r.POST("/admin/drain", adminMiddleware(), setDraining(true))
+	r.POST("/admin/undrain", adminMiddleware(), setDraining(false))
+
+	// OpenAPI 3.0 description of the payment API
+	r.GET("/openapi.json", getOpenAPISpec)
+
+	// Prometheus metrics, text or OpenMetrics by Accept header
+	r.GET("/metrics", gin.WrapH(metricsHandler()))
+
+	// Recent order-service time-to-first-byte
+	r.GET("/debug/dependencies/ttfb", getDependencyTTFB)
+
+	// Order-validation cache contents, for diagnosing stale validations
+	r.GET("/admin/order-cache", adminMiddleware(), getCacheEntries)
+
+	// Drop every cached validation to force revalidation
+	r.DELETE("/admin/order-cache", adminMiddleware(), flushOrderCache)
+
+	// Test mode: make the order-service appear to return a given status
+	if testMode {
+		r.PUT("/test/order-stubs/:order_id", setOrderStub)
+		r.DELETE("/test/order-stubs", clearOrderStubs)
+	}
+
 	// Create payment with resilient validation
-	r.POST("/payments", func(c *gin.Context) {
+	r.POST("/payments", drainMiddleware(), loadSheddingMiddleware(), func(c *gin.Context) {
+		idempotencyKey := c.GetHeader("Idempotency-Key")
+		if idempotencyKey != "" && !isValidIdempotencyKey(idempotencyKey) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be 1-128 printable ASCII characters"})
+			return
+		}
+
 		var req CreatePaymentRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if req.Currency == "" {
+			req.Currency = defaultCurrency
+		}
+
+		// A retried request returns the payment the key already created
+		var createdID string
+		if idempotencyKey != "" {
+			idempotencyKey = idempotencyScope(idempotencyCreate, idempotencyKey)
+			entry, owner, err := claimIdempotencyKey(idempotencyKey, requestFingerprint(req))
+			if err != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			if !owner {
+				paymentsMutex.RLock()
+				stored, exists := payments.Get(entry.paymentID)
+				var original Payment
+				if exists {
+					original = *stored
+				}
+				paymentsMutex.RUnlock()
+				if !exists {
+					c.JSON(http.StatusNotFound, gin.H{"error": "Payment created with this Idempotency-Key no longer exists"})
+					return
+				}
+				c.JSON(http.StatusOK, original)
+				return
+			}
+			defer func() { finishIdempotencyKey(idempotencyKey, entry, createdID) }()
+		}
 
 		// Validate order exists with retry logic
-		if !validateOrder(req.OrderID) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Order not found or validation failed"})
+		debug := c.Query("debug") == "true"
+		skipValidation := testMode && c.GetHeader("X-Test-Skip-Validation") == "true"
+		payment, trace, rejected := createPayment(c.Request.Context(), req, skipValidation)
+		if rejected != nil {
+			if rejected.status == http.StatusServiceUnavailable {
+				c.Header("Retry-After", "5")
+			}
+			if debug && trace != nil {
+				rejected.body["diagnostic"] = trace
+			}
+			c.JSON(rejected.status, rejected.body)
 			return
 		}
-
-		payment := &Payment{
-			ID:        uuid.New().String(),
-			OrderID:   html.EscapeString(req.OrderID),
-			Amount:    req.Amount,
-			Status:    "pending",
-			Method:    html.EscapeString(req.Method),
-			CreatedAt: time.Now(),
+		createdID = payment.ID
+		c.Header("Location", "/payments/"+payment.ID)
+		
+		if debug {
+			c.JSON(http.StatusCreated, diagnosedPayment{payment, trace})
+			return
 		}
-
-		paymentsMutex.Lock()
-		payments[payment.ID] = payment
-		paymentsMutex.Unlock()
 		c.JSON(http.StatusCreated, payment)
 	})
 
+	// Create many payments at once, reporting a result per item
+	r.POST("/payments/batch", drainMiddleware(), loadSheddingMiddleware(), createPaymentsBulk)
+
 	// Get payment - optimized with read lock
-	r.GET("/payments/:payment_id", func(c *gin.Context) {
+	r.GET("/payments/:payment_id", paymentIDMiddleware(), func(c *gin.Context) {
 		paymentID := c.Param("payment_id")
 		
 		paymentsMutex.RLock()
-		payment, exists := payments[paymentID]
+		payment, exists := payments.Get(paymentID)
+		var snapshot Payment
+		if exists {
+			snapshot = *payment
+		}
 		paymentsMutex.RUnlock()
 		
 		if !exists {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
 			return
 		}
-		c.JSON(http.StatusOK, payment)
+		
+		// Pollers revalidate with If-None-Match and skip unchanged bodies
+		c.Header("ETag", paymentETag(snapshot.Version))
+		if notModified(c, snapshot.Version) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.JSON(http.StatusOK, snapshot)
 	})
 
+	// Payments processed in the last N minutes
+	r.GET("/payments/recent", listRecentPayments)
+
+	// Distinct order IDs with payments, for reconciliation
+	r.GET("/payments/orders", listPaymentOrders)
+
+	// Processing outcomes and success ratio per payment method
+	r.GET("/payments/outcomes-by-method", getOutcomesByMethod)
+
+	// Payment creations per time bucket
+	r.GET("/payments/creation-rate", getCreationRate)
+
+	// Counts and amounts by status and method
+	r.GET("/payments/stats", getPaymentStats)
+
+	// Get all payments in a batch with aggregated status
+	r.GET("/payments/batch/:batch_id", getPaymentBatch)
+
+	// Export a payment's lifecycle as an OTLP/JSON trace
+	r.GET("/payments/:payment_id/trace", paymentIDMiddleware(), getPaymentTrace)
+
 	// Process payment - optimized with concurrent processing
-	r.POST("/payments/:payment_id/process", func(c *gin.Context) {
+	r.POST("/payments/:payment_id/process", paymentIDMiddleware(), func(c *gin.Context) {
 		paymentID := c.Param("payment_id")
 		
 		paymentsMutex.RLock()
-		payment, exists := payments[paymentID]
+		payment, exists := payments.Get(paymentID)
+		var currentStatus string
+		var currentVersion int
+		if exists {
+			currentStatus = payment.Status
+			currentVersion = payment.Version
+		}
 		paymentsMutex.RUnlock()
 		
 		if !exists {
@@ -113,38 +451,246 @@ func main() {
 			return
 		}
 
-		// Simulate payment processing with optimized logic
-		var status string
-		if payment.Amount > 1000 {
-			status = "failed"
-		} else {
-			status = "completed"
+		// If-Match is checked again when the payment is claimed
+		expected, ok := requiredVersion(c)
+		if !ok {
+			return
+		}
+		if expected != anyVersion && expected != currentVersion {
+			preconditionFailed(c, currentVersion)
+			return
+		}
+
+		// Only payments still awaiting an outcome can be processed
+		if !isProcessable(currentStatus) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Payment cannot be processed in status %s", currentStatus)})
+			return
+		}
+
+		// Test mode may force the outcome to exercise specific paths
+		var forced string
+		if testMode {
+			switch forced = c.GetHeader("X-Test-Force-Status"); forced {
+			case "", "completed", "failed":
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "X-Test-Force-Status must be completed or failed"})
+				return
+			}
 		}
 		
-		now := time.Now()
+		// ?dry_run=true predicts the outcome and leaves the payment alone
+		if c.Query("dry_run") == "true" {
+			paymentsMutex.RLock()
+			snapshot := *payment
+			paymentsMutex.RUnlock()
+			predicted, err := resolveOutcome(withDryRun(c.Request.Context()), &snapshot, forced, 0)
+			if err != nil {
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment processing was interrupted; payment left unchanged"})
+				} else {
+					c.JSON(http.StatusBadGateway, gin.H{"error": "Payment gateway error"})
+				}
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"dry_run":          true,
+				"payment_id":       snapshot.ID,
+				"current_status":   snapshot.Status,
+				"predicted_status": predicted,
+			})
+			return
+		}
+		
+		// Queue mode hands the work to the worker pool and returns a job
+		if processMode == "queue" {
+			job, err := enqueueProcessing(payment, forced, expected)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusAccepted, job)
+			return
+		}
+		
+		// ?async=true answers 202 at once and settles in the background
+		if c.Query("async") == "true" {
+			previous, err := claimPayment(payment, expected)
+			if err != nil {
+				processingRejected(c, payment, err)
+				return
+			}
+			paymentsMutex.RLock()
+			snapshot := *payment
+			paymentsMutex.RUnlock()
+			processPaymentAsync(payment, previous, forced)
+			c.JSON(http.StatusAccepted, snapshot)
+			return
+		}
+		
+		if err := processPayment(c.Request.Context(), payment, forced, expected); err != nil {
+			switch {
+			case errors.Is(err, errNotProcessable), errors.Is(err, errVersionMismatch):
+				processingRejected(c, payment, err)
+			case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment processing was interrupted; payment left unchanged"})
+			default:
+				c.JSON(http.StatusBadGateway, gin.H{"error": "Payment gateway error"})
+			}
+			return
+		}
+
+		paymentsMutex.RLock()
+		processed := *payment
+		paymentsMutex.RUnlock()
+		c.JSON(http.StatusOK, processed)
+	})
+
+	// Progress of an asynchronously processed payment
+	r.GET("/jobs/:job_id", getProcessingJob)
+
+	// Cancel payment - idempotent, repeated cancels return the cancelled payment
+	r.POST("/payments/:payment_id/cancel", paymentIDMiddleware(), func(c *gin.Context) {
+		paymentID := c.Param("payment_id")
+		expected, ok := expectedVersion(c)
+		if !ok {
+			return
+		}
+		var req CancelRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(req.Reason) > maxCancelReasonLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("reason must be at most %d characters", maxCancelReasonLength)})
+			return
+		}
+		reason, err := sanitizeField("reason", req.Reason)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		
-		// Update with write lock only when necessary
 		paymentsMutex.Lock()
-		payment.Status = status
-		payment.ProcessedAt = &now
-		paymentsMutex.Unlock()
+		defer paymentsMutex.Unlock()
+		
+		payment, exists := payments.Get(paymentID)
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		if !versionMatches(payment, expected) {
+			preconditionFailed(c, payment.Version)
+			return
+		}
+		
+		switch payment.Status {
+		case "cancelled":
+			c.JSON(http.StatusOK, payment)
+			return
+		case "pending":
+			now := clock()
+			payment.Status = "cancelled"
+			payment.CancelledAt = &now
+			payment.CancelReason = reason
+			persistPayment(payment)
+			releaseDailyTotal(payment, float64(payment.Amount))
+			c.JSON(http.StatusOK, payment)
+		default:
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Payment cannot be cancelled in status %s", payment.Status)})
+		}
+	})
 
-		c.JSON(http.StatusOK, payment)
+	// Refund all or part of a completed payment
+	r.POST("/payments/:payment_id/refund", paymentIDMiddleware(), refundPayment)
+
+	// Wipe all payment state between test cases
+	r.DELETE("/payments", adminMiddleware(), resetPayments)
+
+	// Delete payment - completed payments are kept for audit
+	r.DELETE("/payments/:payment_id", paymentIDMiddleware(), func(c *gin.Context) {
+		paymentID := c.Param("payment_id")
+		expected, ok := requiredVersion(c)
+		if !ok {
+			return
+		}
+		
+		paymentsMutex.Lock()
+		defer paymentsMutex.Unlock()
+		
+		payment, exists := payments.Get(paymentID)
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		if !versionMatches(payment, expected) {
+			preconditionFailed(c, payment.Version)
+			return
+		}
+		
+		switch payment.Status {
+		case "completed":
+			c.JSON(http.StatusConflict, gin.H{"error": "Completed payments are immutable and cannot be deleted"})
+			return
+		case "processing":
+			c.JSON(http.StatusConflict, gin.H{"error": "Payment is being processed and cannot be deleted"})
+			return
+		}
+		
+		if err := payments.Delete(paymentID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payment"})
+			return
+		}
+		releaseDailyTotal(payment, dailyCharged(payment))
+		c.Status(http.StatusNoContent)
 	})
 
-	// List payments - optimized with read lock
+	// Internal: cancel pending payments of a cancelled order
+	r.POST("/orders/:order_id/cancel-payments", adminMiddleware(), cancelOrderPayments)
+
+	// Internal: refund every charged payment of a cancelled order
+	r.POST("/orders/:order_id/refund", adminMiddleware(), refundOrderPayments)
+
+	// Check a webhook signature the way a receiver would
+	r.POST("/webhooks/verify", adminMiddleware(), verifyWebhook)
+
+	// List payments, newest first, a page at a time
 	r.GET("/payments", func(c *gin.Context) {
-		paymentsMutex.RLock()
-		paymentList := make([]*Payment, 0, len(payments))
-		for _, payment := range payments {
-			paymentList = append(paymentList, payment)
+		statuses, err := parseStatusFilter(c.Query("status"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		limit, offset, err := parsePagination(c.Query("limit"), c.Query("offset"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		orderID, byOrder := c.GetQuery("order_id")
+		if byOrder && !isValidOrderID(orderID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+			return
+		}
+		
+		// Bound default responses to recent payments unless asked for all
+		var cutoff time.Time
+		if listMaxAge > 0 && c.Query("include_all") != "true" {
+			cutoff = clock().Add(-listMaxAge)
 		}
-		paymentsMutex.RUnlock()
 		
-		c.JSON(http.StatusOK, paymentList)
+		paymentList := listPayments(orderID, statuses, cutoff)
+		total := len(paymentList)
+		start := min(offset, total)
+		page := paymentList[start:min(start+limit, total)]
+		
+		c.JSON(http.StatusOK, paymentPage{
+			Payments: page,
+			Total:    total,
+			Limit:    limit,
+			Offset:   offset,
+		})
 	})
 
-	r.Run(":8003")
+	return r
 }
 
 var httpClient = &http.Client{
@@ -161,84 +707,514 @@ var httpClient = &http.Client{
 	},
 }
 
+// newPayment builds a pending payment from a validated request, picking up
+// any outcome the order-service dictated for the order.
+func newPayment(req CreatePaymentRequest) *Payment {
+	payment := &Payment{
+		ID:        idGenerator.NewID(),
+		OrderID:   req.OrderID,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+		FormattedAmount: formatAmount(float64(req.Amount), req.Currency),
+		Status:    "pending",
+		Method:    req.Method,
+		CreatedAt: clock(),
+		BatchID:   req.BatchID,
+		Version:   1,
+		Metadata:  req.Metadata,
+	}
+	if info, exists := cachedOrder(req.OrderID); exists {
+		payment.orderOutcome = info.PaymentOutcome
+	}
+	return payment
+}
+
+// listPayments returns the payments matching the filters, newest first. An
+// empty orderID means every order; nil statuses means every status. It
+// backs GET /payments and the gRPC ListPayments. The payments are copies
+// taken under the read lock, safe to encode while others are updated.
+func listPayments(orderID string, statuses map[string]bool, cutoff time.Time) []Payment {
+	paymentsMutex.RLock()
+	candidates := payments.List()
+	if orderID != "" {
+		// Served from the order index rather than a full scan
+		candidates = payments.ListByOrder(orderID)
+	}
+	paymentList := make([]Payment, 0)
+	for _, payment := range candidates {
+		if statuses != nil && !statuses[payment.Status] {
+			continue
+		}
+		if payment.CreatedAt.Before(cutoff) {
+			continue
+		}
+		paymentList = append(paymentList, *payment)
+	}
+	paymentsMutex.RUnlock()
+
+	sort.Slice(paymentList, func(i, j int) bool {
+		if !paymentList[i].CreatedAt.Equal(paymentList[j].CreatedAt) {
+			return paymentList[i].CreatedAt.After(paymentList[j].CreatedAt)
+		}
+		return paymentList[i].ID < paymentList[j].ID
+	})
+	return paymentList
+}
+
+// createRejection is why createPayment refused a request: the status the
+// REST API answers with and the error body.
+type createRejection struct {
+	status int
+	body   gin.H
+}
+
+// createPayment validates a request, checks its order and stores the new
+// payment. It is the core of POST /payments, shared with the gRPC API. The
+// payment returned is a copy taken before it was stored; the validation
+// trace is nil when order validation was skipped.
+func createPayment(ctx context.Context, req CreatePaymentRequest, skipValidation bool) (*Payment, *validationTrace, *createRejection) {
+	if body := validateCreateRequest(&req); body != nil {
+		return nil, nil, &createRejection{http.StatusBadRequest, body}
+	}
+
+	var trace *validationTrace
+	if !skipValidation {
+		result := traceOrderValidation(ctx, req.OrderID)
+		trace = &result
+		if !result.Valid {
+			if result.Transient {
+				// Worth retrying: the order-service didn't answer
+				return nil, trace, &createRejection{http.StatusServiceUnavailable, gin.H{"error": "Order service temporarily unavailable"}}
+			}
+			return nil, trace, &createRejection{http.StatusBadRequest, gin.H{"error": "Order not found or validation failed"}}
+		}
+	}
+
+	// Optionally hold the amount to the order total, within tolerance
+	if err := checkOrderTotal(req.OrderID, float64(req.Amount)); err != nil {
+		return nil, trace, &createRejection{http.StatusBadRequest, gin.H{"error": err.Error()}}
+	}
+	reservation, ok := reserveDailyTotal(req.OrderID, float64(req.Amount))
+	if !ok {
+		return nil, trace, &createRejection{http.StatusConflict, gin.H{"error": "Payment would exceed the daily total for this order"}}
+	}
+
+	payment := newPayment(req)
+
+	// Snapshot for the webhook and the caller before other requests can
+	// see the payment
+	created := *payment
+	paymentsMutex.Lock()
+	err := payments.Save(payment)
+	paymentsMutex.Unlock()
+	if err != nil {
+		// Nothing was charged against the order, so the cap is given back
+		reservation.release()
+		return nil, trace, &createRejection{http.StatusInternalServerError, gin.H{"error": "Failed to store payment"}}
+	}
+	paymentsCreated.Inc()
+	notifyPaymentEvent(eventPaymentCreated, created)
+	annotatePaymentSpan(ctx, created)
+	return &created, trace, nil
+}
+
+// processPayment charges a payment and records the outcome. A non-empty
+// forced status (test mode) replaces the gateway decision, as does an
+// outcome dictated by the order-service.
+func processPayment(ctx context.Context, payment *Payment, forced string, expected int) error {
+	previous, err := claimPayment(payment, expected)
+	if err != nil {
+		return err
+	}
+	return settlePayment(ctx, payment, previous, forced, processingDelay)
+}
+
+// claimPayment moves a payment to "processing" so concurrent requests can't
+// process it twice, returning the status it had before. The payment must
+// still be at the expected version.
+func claimPayment(payment *Payment, expected int) (string, error) {
+	paymentsMutex.Lock()
+	defer paymentsMutex.Unlock()
+	if !versionMatches(payment, expected) {
+		return "", errVersionMismatch
+	}
+	previous := payment.Status
+	if !isProcessable(previous) {
+		return "", errNotProcessable
+	}
+	payment.Status = "processing"
+	persistPayment(payment)
+	return previous, nil
+}
+
+// processingRejected answers a claim that lost to a concurrent update.
+func processingRejected(c *gin.Context, payment *Payment, err error) {
+	if errors.Is(err, errVersionMismatch) {
+		paymentsMutex.RLock()
+		current := payment.Version
+		paymentsMutex.RUnlock()
+		preconditionFailed(c, current)
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "Payment is already being processed"})
+}
+
+// settlePayment decides the outcome of a claimed payment after the
+// simulated delay and records it. On error the payment goes back to
+// previous.
+func settlePayment(ctx context.Context, payment *Payment, previous, forced string, delay time.Duration) error {
+	paymentsProcessing.Inc()
+	defer paymentsProcessing.Dec()
+	
+	status, err := resolveOutcome(ctx, payment, forced, delay)
+	if err != nil {
+		// Nothing was decided - hand the payment back in its previous state
+		paymentsMutex.Lock()
+		payment.Status = previous
+		persistPayment(payment)
+		paymentsMutex.Unlock()
+		return err
+	}
+	
+	now := clock()
+	latency := now.Sub(payment.CreatedAt).Milliseconds()
+	
+	// Update with write lock only when necessary
+	paymentsMutex.Lock()
+	payment.Status = status
+	if status != "deferred" {
+		payment.ProcessedAt = &now
+		payment.ProcessingLatencyMs = &latency
+	}
+	persistPayment(payment)
+	if status == "failed" {
+		releaseDailyTotal(payment, float64(payment.Amount))
+	}
+	snapshot := *payment
+	paymentsMutex.Unlock()
+	annotatePaymentSpan(ctx, snapshot)
+	paymentsProcessed.WithLabelValues(status).Inc()
+	notifyPaymentEvent(eventPaymentProcessed, snapshot)
+	return nil
+}
+
+// processPaymentAsync settles a claimed payment in the background after
+// asyncProcessDelay. The outcome is visible via GET /payments/:id and the
+// payment.processed webhook.
+func processPaymentAsync(payment *Payment, previous, forced string) {
+	asyncProcessing.Add(1)
+	go func() {
+		defer asyncProcessing.Done()
+		if err := settlePayment(context.Background(), payment, previous, forced, asyncProcessDelay); err != nil {
+			logger.Error("async payment processing failed", "payment_id", payment.ID, "error", err)
+		}
+	}()
+}
+
+// resolveOutcome waits out the simulated processing delay and then decides
+// the payment's status. It returns ctx's error if cancelled along the way.
+func resolveOutcome(ctx context.Context, payment *Payment, forced string, delay time.Duration) (string, error) {
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	
+	if forced != "" {
+		return forced, nil
+	}
+	if payment.orderOutcome != "" {
+		return payment.orderOutcome, nil
+	}
+	return chargePayment(ctx, payment)
+}
+
+// isProcessable reports whether a payment in the given status may be sent
+// to the gateway. Terminal states (completed, failed, cancelled, expired,
+// refunded, charged back) are rejected.
+func isProcessable(status string) bool {
+	return status == "pending" || status == "deferred"
+}
+
 func validateOrder(orderID string) bool {
+	return traceOrderValidation(context.Background(), orderID).Valid
+}
+
+// traceOrderValidation validates an order and records how the decision was
+// reached, for the debug output of POST /payments.
+func traceOrderValidation(ctx context.Context, orderID string) validationTrace {
 	// Sanitize and validate orderID
 	if !isValidOrderID(orderID) {
-		return false
+		return validationTrace{}
 	}
+	trace := validationTrace{FormatValid: true}
+	start := time.Now()
+	defer func() { orderValidationDuration.Observe(time.Since(start).Seconds()) }()
 	
 	// Check cache first for performance optimization
-	cacheMutex.RLock()
-	if cached, exists := orderValidationCache[orderID]; exists {
-		cacheMutex.RUnlock()
-		return cached
+	cacheMutex.Lock()
+	if cached, exists := orderValidationCache[orderID]; exists && !orderEntryExpired(cached, clock()) {
+		touchOrderCache(orderID)
+		cacheMutex.Unlock()
+		orderValidationCacheHits.Inc()
+		trace.CacheHit = true
+		trace.Valid = cached.Valid
+		return trace
 	}
-	cacheMutex.RUnlock()
+	cacheMutex.Unlock()
+	orderValidationCacheMisses.Inc()
 	
 	// Clean cache periodically
-	if time.Since(lastCacheClean) > cacheExpiry {
+	if orderCacheExpired() {
 		cleanOrderCache()
 	}
 	
+	// Coalesce concurrent validations of the same order into one request.
+	// The call runs under the context of whoever started it; every caller
+	// stops waiting when its own context ends
+	led := false
+	results := inflightValidations.DoChan(orderID, func() (any, error) {
+		led = true
+		fetched := trace
+		fetchOrderValidation(ctx, orderID, &fetched)
+		return fetched, nil
+	})
+	select {
+	case result := <-results:
+		shared := result.Val.(validationTrace)
+		if led {
+			return shared
+		}
+		if shared.cancelled && ctx.Err() == nil {
+			// Whoever made the call gave up on it; this caller still wants an answer
+			return traceOrderValidation(ctx, orderID)
+		}
+		shared.Coalesced = true
+		return shared
+	case <-ctx.Done():
+		trace.giveUp(ctx)
+		return trace
+	}
+}
+
+func fetchOrderValidation(parent context.Context, orderID string, trace *validationTrace) {
 	// Use only allowed hosts to prevent SSRF
-	orderURL := fmt.Sprintf("http://localhost:8002/orders/%s", html.EscapeString(orderID))
+	orderURL := fmt.Sprintf("%s/orders/%s", orderServiceURL, html.EscapeString(orderID))
 	if !isAllowedURL(orderURL) {
-		return false
+		return
 	}
+	trace.Allowlisted = true
+	
+	// Bound the whole validation, attempts and backoff included. A caller
+	// that goes away stops it too, so no further attempts are made
+	ctx, cancel := context.WithTimeout(parent, validationTotalTimeout)
+	defer cancel()
 	
-	// Retry logic with exponential backoff for resilience
-	for attempt := 0; attempt < 3; attempt++ {
-		resp, err := httpClient.Get(orderURL)
+	// Retry logic with jittered exponential backoff for resilience
+	for attempt := 0; attempt < validationMaxAttempts; attempt++ {
+		final := attempt == validationMaxAttempts-1
+		if attempt > 0 {
+			orderValidationRetries.Inc()
+		}
+		// Don't call an order-service the breaker has given up on
+		if !orderBreaker.allow() {
+			trace.LastError = errBreakerOpen.Error()
+			trace.Transient = true
+			return
+		}
+		trace.Attempts++
+		resp, err := fetchOrder(ctx, orderID, orderURL)
 		if err != nil {
-			fmt.Printf("Order validation attempt %d failed for %s: %v\n", attempt+1, orderID, err)
-			if attempt == 2 {
-				// Final attempt failed - cache as invalid
-				cacheOrderValidation(orderID, false)
-				return false
+			if errors.Is(ctx.Err(), context.Canceled) {
+				// The caller went away, which says nothing about the order-service
+				orderBreaker.abandon()
+			} else {
+				orderBreaker.failure()
+			}
+			logger.WarnContext(ctx, "order validation attempt failed",
+				"order_id", orderID, "attempt", attempt+1, "error", err.Error())
+			trace.LastError = err.Error()
+			if ctx.Err() != nil {
+				// Out of time or abandoned - says nothing about the order, so don't cache
+				trace.giveUp(ctx)
+				return
+			}
+			if final || !takeRetryToken() {
+				// Out of attempts or retry budget - the order-service is
+				// unreachable, which says nothing about the order, so don't cache
+				trace.Transient = true
+				return
 			}
 			// Wait before retry with exponential backoff
-			time.Sleep(time.Duration(100*(attempt+1)) * time.Millisecond)
+			if !backoff(ctx, retryDelay(attempt, validationRetryBaseDelay)) {
+				trace.giveUp(ctx)
+				return
+			}
 			continue
 		}
-		defer resp.Body.Close()
+		trace.StatusCode = resp.StatusCode
+		logger.DebugContext(ctx, "order validation response",
+			"order_id", orderID, "attempt", attempt+1, "status_code", resp.StatusCode)
+		
+		// Server errors are the order-service's problem, not the order's
+		if resp.StatusCode >= 500 {
+			discardBody(resp)
+			orderBreaker.failure()
+			if final || !takeRetryToken() {
+				trace.Transient = true
+				return
+			}
+			if !backoff(ctx, retryDelay(attempt, validationRetryBaseDelay)) {
+				trace.giveUp(ctx)
+				return
+			}
+			continue
+		}
+		orderBreaker.success()
 		
 		// Handle rate limiting with retry
 		if resp.StatusCode == 429 {
-			if attempt == 2 {
-				// Final attempt - cache as valid to prevent cascade failures
-				cacheOrderValidation(orderID, true)
-				return true
+			discardBody(resp)
+			if final || !takeRetryToken() {
+				// Still limited - the order is unverified, so nothing is
+				// cached and the caller is told to retry later
+				trace.LastError = errOrderRateLimited.Error()
+				trace.Transient = true
+				return
 			}
 			// Wait longer for rate limit
-			time.Sleep(time.Duration(200*(attempt+1)) * time.Millisecond)
+			if !backoff(ctx, retryDelay(attempt, 2*validationRetryBaseDelay)) {
+				trace.giveUp(ctx)
+				return
+			}
 			continue
 		}
 		
-		isValid := resp.StatusCode == http.StatusOK
-		cacheOrderValidation(orderID, isValid)
-		return isValid
+		if attempt > 0 {
+			orderValidationRetrySuccesses.Inc()
+		}
+		trace.Valid = resp.StatusCode == http.StatusOK
+		info := orderInfo{Valid: trace.Valid}
+		if trace.Valid {
+			info = decodeOrderInfo(resp.Body)
+		}
+		discardBody(resp)
+		cacheOrderValidation(orderID, info)
+		return
 	}
-	
-	return false
 }
 
-func cacheOrderValidation(orderID string, isValid bool) {
+// discardBody reads what is left of a response and closes it, so the
+// connection goes back to the pool for the next attempt.
+func discardBody(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
+
+func cacheOrderValidation(orderID string, info orderInfo) {
+	info.CachedAt = clock()
 	cacheMutex.Lock()
-	orderValidationCache[orderID] = isValid
+	orderValidationCache[orderID] = info
+	touchOrderCache(orderID)
+	evictOrderCache()
 	cacheMutex.Unlock()
 }
 
+// cachedOrder returns what is known about a validated order without calling
+// the order-service, for checks that run after validateOrder.
+func cachedOrder(orderID string) (orderInfo, bool) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	info, exists := orderValidationCache[orderID]
+	if !exists || orderEntryExpired(info, clock()) {
+		return orderInfo{}, false
+	}
+	touchOrderCache(orderID)
+	return info, true
+}
+
+// decodeOrderInfo reads the order details from an order-service response.
+// A body that cannot be decoded still counts as a valid order, just without
+// details.
+func decodeOrderInfo(body io.Reader) orderInfo {
+	var order struct {
+		TotalAmount    float64 `json:"total_amount"`
+		Currency       string  `json:"currency"`
+		Status         string  `json:"status"`
+		UserID         string  `json:"user_id"`
+		PaymentOutcome string  `json:"payment_outcome"`
+	}
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&order); err != nil {
+		return orderInfo{Valid: true}
+	}
+	info := orderInfo{
+		Valid:       true,
+		TotalAmount: order.TotalAmount,
+		Currency:    order.Currency,
+		Status:      order.Status,
+		UserID:      order.UserID,
+	}
+	if order.PaymentOutcome == "completed" || order.PaymentOutcome == "failed" {
+		info.PaymentOutcome = order.PaymentOutcome
+	}
+	return info
+}
+
+// orderCacheExpired reports whether the cache is due for a sweep. The
+// production clock carries a monotonic reading so wall-clock jumps do not
+// affect the comparison; if an injected clock still moves backwards the
+// sweep runs anyway, so a skewed clock never keeps entries alive.
+func orderCacheExpired() bool {
+	cacheMutex.RLock()
+	elapsed := clock().Sub(lastCacheClean)
+	cacheMutex.RUnlock()
+	return elapsed < 0 || elapsed > cacheExpiry
+}
+
+// orderEntryExpired reports whether a cache entry has outlived cacheExpiry.
+// An entry stamped in the future (the clock moved backwards) counts as
+// expired for the same reason.
+func orderEntryExpired(info orderInfo, now time.Time) bool {
+	age := now.Sub(info.CachedAt)
+	return age < 0 || age > cacheExpiry
+}
+
+// cleanOrderCache evicts the entries older than cacheExpiry; fresh ones
+// stay cached.
 func cleanOrderCache() {
 	cacheMutex.Lock()
-	orderValidationCache = make(map[string]bool) // Simple cache reset
-	lastCacheClean = time.Now()
+	now := clock()
+	for orderID, info := range orderValidationCache {
+		if orderEntryExpired(info, now) {
+			removeCachedOrder(orderID)
+		}
+	}
+	lastCacheClean = now
 	cacheMutex.Unlock()
 }
 
+// compactOrderIDPattern is an order UUID written without hyphens.
+var compactOrderIDPattern = regexp.MustCompile(`^[a-fA-F0-9]{32}$`)
+
+// isValidOrderID accepts the order service's IDs: canonical UUIDs, or the
+// same 32 hex digits without hyphens. Stray or misplaced hyphens ("-",
+// "dead-beef") and bare hex fragments are rejected.
 func isValidOrderID(orderID string) bool {
-	// Allow UUIDs and alphanumeric characters with hyphens
-	matched, _ := regexp.MatchString(`^[a-fA-F0-9-]+$`, orderID)
-	return matched && len(orderID) > 0 && len(orderID) <= 50
+	if len(orderID) == 0 || len(orderID) > 50 {
+		return false
+	}
+	if compactOrderIDPattern.MatchString(orderID) {
+		return true
+	}
+	if len(orderID) != 36 {
+		return false
+	}
+	_, err := uuid.Parse(orderID)
+	return err == nil
 }
 
 func isAllowedURL(targetURL string) bool {
@@ -263,8 +1239,16 @@ func isAllowedURL(targetURL string) bool {
 
 func csrfMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Skip CSRF for health check and GET requests
-		if c.Request.URL.Path == "/health" || c.Request.Method == "GET" {
+		// Skip CSRF for health check, the API spec and GET requests
+		if c.Request.URL.Path == "/health" || c.Request.URL.Path == "/openapi.json" {
+			c.Next()
+			return
+		}
+		if c.Request.Method == "GET" {
+			// When enforcing, every GET hands out a token for later writes
+			if csrfEnforce {
+				c.Header("X-CSRF-Token", issueCSRFToken())
+			}
 			c.Next()
 			return
 		}
@@ -272,7 +1256,14 @@ func csrfMiddleware() gin.HandlerFunc {
 		// Enhanced CSRF protection for POST/PUT/DELETE
 		if c.Request.Method == "POST" || c.Request.Method == "PUT" || c.Request.Method == "DELETE" {
 			token := c.GetHeader("X-CSRF-Token")
-			if token == "" {
+			if csrfEnforce {
+				if token == "" || !validCSRFToken(token) {
+					logger.WarnContext(c.Request.Context(), "csrf token rejected",
+						"method", c.Request.Method, "path", c.Request.URL.Path, "token_prefix", tokenPrefix(token))
+					c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing or invalid CSRF token"})
+					return
+				}
+			} else if token == "" {
 				// Generate and provide a token, allow request in dev mode
 				generatedToken := generateCSRFToken()
 				c.Header("X-Generated-CSRF-Token", generatedToken)
@@ -280,8 +1271,9 @@ func csrfMiddleware() gin.HandlerFunc {
 				// Invalid token length - provide new one
 				c.Header("X-Generated-CSRF-Token", generateCSRFToken())
 			}
-			// Log CSRF token usage for monitoring
-			fmt.Printf("CSRF token validation for %s %s\n", c.Request.Method, c.Request.URL.Path)
+			// Log CSRF token usage for monitoring - never the full token
+			logger.DebugContext(c.Request.Context(), "csrf token validation",
+				"method", c.Request.Method, "path", c.Request.URL.Path, "token_prefix", tokenPrefix(token))
 		}
 		c.Next()
 	}
@@ -0,0 +1,941 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	logLevel.Set(slog.LevelError + 1)
+	os.Exit(m.Run())
+}
+
+// setVar overrides a package-level setting for the duration of a test.
+func setVar[T any](t *testing.T, target *T, value T) {
+	t.Helper()
+	previous := *target
+	*target = value
+	t.Cleanup(func() { *target = previous })
+}
+
+// useFakeClock makes clock() return a time that only moves when the test
+// calls the returned function.
+func useFakeClock(t *testing.T) (advance func(time.Duration)) {
+	t.Helper()
+	var mu sync.Mutex
+	now := time.Now()
+	setVar(t, &clock, func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	})
+	return func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+}
+
+// resetState empties every store, cache and limiter the handlers share, so
+// each test starts from a freshly started service.
+func resetState() {
+	paymentsMutex.Lock()
+	payments = newMemoryStore()
+	paymentsMutex.Unlock()
+
+	clearOrderCache()
+	cacheMutex.Lock()
+	lastCacheClean = clock()
+	cacheMutex.Unlock()
+
+	idempotencyMutex.Lock()
+	idempotencyKeys = make(map[string]*idempotencyEntry)
+	idempotencyMutex.Unlock()
+
+	dailyTotalsMutex.Lock()
+	dailyTotals = make(map[string]float64)
+	dailyTotalsDay = ""
+	dailyTotalsMutex.Unlock()
+
+	orderBreaker.mu.Lock()
+	orderBreaker.failures = 0
+	orderBreaker.trialing = false
+	orderBreaker.setState(breakerClosed)
+	orderBreaker.mu.Unlock()
+
+	orderBatchMutex.Lock()
+	orderBatchUnsupported = false
+	orderBatchMutex.Unlock()
+
+	rateLimitMutex.Lock()
+	rateLimitBuckets = make(map[string]*tokenBucket)
+	rateLimitMutex.Unlock()
+
+	retryBudgetMutex.Lock()
+	retryBudgetLast = time.Time{}
+	retryBudgetMutex.Unlock()
+
+	csrfMutex.Lock()
+	issuedCSRFTokens = make(map[string]time.Time)
+	csrfTokenOrder.Init()
+	csrfMutex.Unlock()
+
+	nonceMutex.Lock()
+	seenNonces = make(map[string]time.Time)
+	nonceMutex.Unlock()
+
+	orderStubsMutex.Lock()
+	orderStubs = make(map[string]int)
+	orderStubsMutex.Unlock()
+
+	processingJobsMux.Lock()
+	processingJobs = make(map[string]*ProcessingJob)
+	lastJobPrune = clock()
+	processingJobsMux.Unlock()
+
+	readinessMutex.Lock()
+	readinessChecked = time.Time{}
+	readinessMutex.Unlock()
+
+	ttfbSampleMutex.Lock()
+	ttfbSamples = ttfbSamples[:0]
+	ttfbNext = 0
+	ttfbSampleMutex.Unlock()
+
+	methodFailureMutex.Lock()
+	methodFailureRand = nil
+	dryRunFailureRand = nil
+	methodFailureMutex.Unlock()
+
+	faultMutex.Lock()
+	faultRand = nil
+	faultMutex.Unlock()
+
+	draining.Store(false)
+}
+
+// newTestRouter resets shared state and builds the service's router.
+// Background settlements and webhooks started by the test are waited for
+// when it ends.
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	resetState()
+	t.Cleanup(func() {
+		asyncProcessing.Wait()
+		webhookDeliveries.Wait()
+		resetState()
+	})
+	return setupRouter()
+}
+
+// orderService is a fake order-service that counts the connections made
+// to it.
+type orderService struct {
+	*httptest.Server
+	opened atomic.Int64 // connections accepted so far
+	open   atomic.Int64 // connections not yet closed
+}
+
+// newOrderService starts a fake order-service and points order validation
+// at it for the duration of the test.
+func newOrderService(t *testing.T, handler http.HandlerFunc) *orderService {
+	t.Helper()
+	service := &orderService{Server: httptest.NewUnstartedServer(handler)}
+	service.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			service.opened.Add(1)
+			service.open.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			service.open.Add(-1)
+		}
+	}
+	service.Start()
+	t.Cleanup(func() {
+		httpClient.CloseIdleConnections()
+		service.Close()
+	})
+	host := strings.TrimPrefix(service.URL, "http://")
+	setVar(t, &allowedHosts, append([]string{host}, allowedHosts...))
+	setVar(t, &orderServiceURL, service.URL)
+	return service
+}
+
+// ordersFound answers every order lookup with 200 and a pending order.
+func ordersFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":%q,"status":"pending"}`, path.Base(r.URL.Path))
+}
+
+// doRequest serves one request through handler. A non-empty body is sent
+// as JSON; headers are name/value pairs.
+func doRequest(t *testing.T, handler http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// decodeJSON decodes a response body, failing the test if it isn't JSON.
+func decodeJSON[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var value T
+	if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+	return value
+}
+
+// createPaymentBody is a POST /payments body for the given order.
+func createPaymentBody(orderID string, amount float64, method string) string {
+	return fmt.Sprintf(`{"order_id":%q,"amount":%v,"method":%q}`, orderID, amount, method)
+}
+
+// mustCreatePayment creates a payment through the API and fails the test
+// unless it is created.
+func mustCreatePayment(t *testing.T, handler http.Handler, orderID string, amount float64, method string) Payment {
+	t.Helper()
+	w := doRequest(t, handler, http.MethodPost, "/payments", createPaymentBody(orderID, amount, method))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /payments = %d %s, want 201", w.Code, w.Body.String())
+	}
+	return decodeJSON[Payment](t, w)
+}
+
+// mustProcessPayment processes a payment synchronously and fails the test
+// unless it completes.
+func mustProcessPayment(t *testing.T, handler http.Handler, paymentID string) Payment {
+	t.Helper()
+	w := doRequest(t, handler, http.MethodPost, "/payments/"+paymentID+"/process", "", "If-Match", "*")
+	if w.Code != http.StatusOK {
+		t.Fatalf("process = %d %s, want 200", w.Code, w.Body.String())
+	}
+	payment := decodeJSON[Payment](t, w)
+	if payment.Status != "completed" {
+		t.Fatalf("processed payment status = %s, want completed", payment.Status)
+	}
+	return payment
+}
+
+// GET /payments answers with copies, so encoding them doesn't race with
+// background settlement (run with -race).
+func TestListPaymentsWhileSettling(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &asyncProcessDelay, 0)
+
+	for i := 0; i < 20; i++ {
+		payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+		w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process?async=true", "", "If-Match", "*")
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("async process = %d %s, want 202", w.Code, w.Body.String())
+		}
+		if w := doRequest(t, r, http.MethodGet, "/payments", ""); w.Code != http.StatusOK {
+			t.Fatalf("GET /payments = %d %s", w.Code, w.Body.String())
+		}
+	}
+	asyncProcessing.Wait()
+
+	page := decodeJSON[paymentPage](t, doRequest(t, r, http.MethodGet, "/payments?status=completed", ""))
+	if page.Total != 20 {
+		t.Fatalf("completed payments = %d, want 20", page.Total)
+	}
+}
+
+func TestAsyncProcessing(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &asyncProcessDelay, 50*time.Millisecond)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+
+	w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process?async=true", "", "If-Match", "*")
+	if w.Code != http.StatusAccepted || decodeJSON[Payment](t, w).Status != "processing" {
+		t.Fatalf("async process = %d %s, want 202 with the payment processing", w.Code, w.Body.String())
+	}
+	if got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+payment.ID, "")); got.Status != "processing" {
+		t.Fatalf("status while settling = %s, want processing", got.Status)
+	}
+
+	asyncProcessing.Wait()
+	if got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+payment.ID, "")); got.Status != "completed" || got.ProcessedAt == nil {
+		t.Fatalf("status after settling = %s, want completed with processed_at", got.Status)
+	}
+}
+
+// Concurrent payments for the same uncached order share one order-service
+// request.
+func TestConcurrentCreationsShareOneValidation(t *testing.T) {
+	r := newTestRouter(t)
+	release := make(chan struct{})
+	var calls atomic.Int32
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		<-release
+		ordersFound(w, req)
+	})
+	orderID := uuid.NewString()
+
+	const creations = 5
+	codes := make(chan int, creations)
+	for i := 0; i < creations; i++ {
+		go func() {
+			codes <- doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(orderID, 10, "pix")).Code
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond) // let the others join the in-flight call
+	close(release)
+	for i := 0; i < creations; i++ {
+		if code := <-codes; code != http.StatusCreated {
+			t.Fatalf("POST /payments = %d, want 201", code)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("order-service called %d times, want 1", calls.Load())
+	}
+}
+
+// A caller waiting on another's in-flight validation stops when its own
+// context ends, without cancelling the shared call.
+func TestCoalescedValidationHonoursCallerContext(t *testing.T) {
+	newTestRouter(t)
+	release := make(chan struct{})
+	var calls atomic.Int32
+	newOrderService(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		ordersFound(w, r)
+	})
+	orderID := uuid.NewString()
+
+	led := make(chan validationTrace, 1)
+	go func() { led <- traceOrderValidation(context.Background(), orderID) }()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	waited := traceOrderValidation(ctx, orderID)
+	if waited.Valid || !waited.Transient || waited.LastError != errValidationDeadline.Error() {
+		t.Fatalf("waiter trace = %+v, want it to give up at its deadline", waited)
+	}
+
+	shared := make(chan validationTrace, 1)
+	go func() { shared <- traceOrderValidation(context.Background(), orderID) }()
+	time.Sleep(50 * time.Millisecond) // let it join the in-flight call
+	close(release)
+	if first := <-led; !first.Valid || first.Coalesced {
+		t.Fatalf("leader trace = %+v, want it valid and not coalesced", first)
+	}
+	if second := <-shared; !second.Valid || !second.Coalesced {
+		t.Fatalf("second waiter trace = %+v, want the leader's answer, coalesced", second)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("order-service called %d times, want 1", calls.Load())
+	}
+}
+
+// leakSnapshot is the goroutine count before a scenario, to compare with
+// what is left running after it.
+type leakSnapshot struct {
+	goroutines int
+	orders     *orderService
+}
+
+// snapshotLeaks records the goroutines running now. Connections are
+// checked against orders, which must have none open at the end.
+func snapshotLeaks(orders *orderService) leakSnapshot {
+	return leakSnapshot{goroutines: runtime.NumGoroutine(), orders: orders}
+}
+
+// check fails the test if the scenario left more goroutines running or a
+// connection to the order-service open. Goroutines and connections take a
+// moment to wind down, so it polls for up to two seconds.
+func (s leakSnapshot) check(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		goroutines, open := runtime.NumGoroutine(), s.orders.open.Load()
+		if goroutines <= s.goroutines && open == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			stacks := make([]byte, 1<<20)
+			stacks = stacks[:runtime.Stack(stacks, true)]
+			t.Fatalf("leaked %d goroutines and %d order-service connections; running goroutines:\n%s",
+				goroutines-s.goroutines, open, stacks)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// freeAddr returns a loopback address with a port nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// Starting the service, serving payments through every background path and
+// shutting it down leaves no goroutine or connection behind.
+func TestRunShutsDownWithoutLeaks(t *testing.T) {
+	resetState()
+	t.Cleanup(resetState)
+	orders := newOrderService(t, ordersFound)
+	setVar(t, &webhookURL, orders.URL+"/webhooks")
+	setVar(t, &asyncProcessDelay, time.Millisecond)
+	setVar(t, &processMode, "queue")
+	_, grpcAddrPort, _ := net.SplitHostPort(freeAddr(t))
+	setVar(t, &grpcPort, grpcAddrPort)
+	addr := freeAddr(t)
+	leaks := snapshotLeaks(orders)
+
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- run(ctx, addr) }()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	send := func(method, path, body string) (int, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, "http://"+addr+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", "*")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		payload, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp.StatusCode, payload
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		resp, err := client.Get("http://" + addr + "/health")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("service did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var created []Payment
+	for i := 0; i < 2; i++ {
+		status, payload := send(http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"))
+		var payment Payment
+		if err := json.Unmarshal(payload, &payment); err != nil || status != http.StatusCreated {
+			t.Fatalf("create = %d %s, want 201", status, payload)
+		}
+		created = append(created, payment)
+	}
+	if status, payload := send(http.MethodPost, "/payments/"+created[0].ID+"/process", ""); status != http.StatusAccepted {
+		t.Fatalf("queued process = %d %s, want 202", status, payload)
+	}
+	setVar(t, &processMode, "sync")
+	if status, payload := send(http.MethodPost, "/payments/"+created[1].ID+"/process?async=true", ""); status != http.StatusAccepted {
+		t.Fatalf("async process = %d %s, want 202", status, payload)
+	}
+
+	stop()
+	if err := <-stopped; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	client.CloseIdleConnections()
+	leaks.check(t)
+}
+
+// A processed payment reports how long it waited since creation; a pending
+// one reports nothing.
+func TestProcessedPaymentReportsLatency(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+
+	created := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	if created.ProcessingLatencyMs != nil {
+		t.Fatalf("pending payment latency = %d, want none", *created.ProcessingLatencyMs)
+	}
+	advance(1500 * time.Millisecond)
+	mustProcessPayment(t, r, created.ID)
+
+	w := doRequest(t, r, http.MethodGet, "/payments/"+created.ID, "")
+	fetched := decodeJSON[Payment](t, w)
+	if fetched.ProcessingLatencyMs == nil || *fetched.ProcessingLatencyMs != 1500 {
+		t.Fatalf("processing_latency_ms = %v, want 1500", fetched.ProcessingLatencyMs)
+	}
+}
+
+// Cancelling twice returns the same cancelled payment; only pending
+// payments can be cancelled.
+func TestCancelIsIdempotent(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+
+	first := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/cancel", "")
+	advance(time.Minute)
+	second := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/cancel", "")
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("cancels = %d, %d, want 200 both times", first.Code, second.Code)
+	}
+	cancelled, again := decodeJSON[Payment](t, first), decodeJSON[Payment](t, second)
+	if cancelled.Status != "cancelled" || cancelled.CancelledAt == nil || !again.CancelledAt.Equal(*cancelled.CancelledAt) {
+		t.Fatalf("cancels = %+v then %+v, want one cancellation time", cancelled, again)
+	}
+
+	processed := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+processed.ID+"/cancel", ""); w.Code != http.StatusConflict {
+		t.Fatalf("cancel completed payment = %d, want 409", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+uuid.NewString()+"/cancel", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("cancel unknown payment = %d, want 404", w.Code)
+	}
+}
+
+func TestCancelReason(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	target := "/payments/" + payment.ID + "/cancel"
+
+	tooLong := fmt.Sprintf(`{"reason":%q}`, strings.Repeat("x", maxCancelReasonLength+1))
+	if w := doRequest(t, r, http.MethodPost, target, tooLong); w.Code != http.StatusBadRequest {
+		t.Fatalf("cancel with an over-long reason = %d, want 400", w.Code)
+	}
+	w := doRequest(t, r, http.MethodPost, target, `{"reason":"customer <changed> mind"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel with a reason = %d %s, want 200", w.Code, w.Body.String())
+	}
+	if got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+payment.ID, "")); got.CancelReason != "customer &lt;changed&gt; mind" {
+		t.Fatalf("stored reason = %q, want it escaped", got.CancelReason)
+	}
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process", "", "If-Match", "*"); w.Code != http.StatusConflict {
+		t.Fatalf("process a cancelled payment = %d, want 409", w.Code)
+	}
+}
+
+// ordersMissing answers every order lookup with 404.
+func ordersMissing(w http.ResponseWriter, r *http.Request) {
+	http.NotFound(w, r)
+}
+
+func TestTestModeHeaders(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersMissing)
+	skip := []string{"X-Test-Skip-Validation", "true"}
+
+	if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"), skip...); w.Code != http.StatusBadRequest {
+		t.Fatalf("skip header outside test mode = %d, want 400 for the missing order", w.Code)
+	}
+
+	setVar(t, &testMode, true)
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"), skip...)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("skip header in test mode = %d %s, want 201", w.Code, w.Body.String())
+	}
+	payment := decodeJSON[Payment](t, w)
+
+	process := "/payments/" + payment.ID + "/process"
+	if w := doRequest(t, r, http.MethodPost, process, "", "X-Test-Force-Status", "refunded", "If-Match", "*"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unsupported forced status = %d, want 400", w.Code)
+	}
+	w = doRequest(t, r, http.MethodPost, process, "", "X-Test-Force-Status", "failed", "If-Match", "*")
+	if w.Code != http.StatusOK || decodeJSON[Payment](t, w).Status != "failed" {
+		t.Fatalf("forced failure = %d %s, want a failed payment", w.Code, w.Body.String())
+	}
+}
+
+func TestDebugCreationReturnsValidationDiagnostic(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	orderID := uuid.NewString()
+
+	type debugResponse struct {
+		Payment
+		Diagnostic *validationTrace `json:"diagnostic"`
+	}
+	w := doRequest(t, r, http.MethodPost, "/payments?debug=true", createPaymentBody(orderID, 10, "pix"))
+	first := decodeJSON[debugResponse](t, w)
+	if w.Code != http.StatusCreated || first.ID == "" || first.Diagnostic == nil {
+		t.Fatalf("debug create = %d %s, want the payment with a diagnostic", w.Code, w.Body.String())
+	}
+	if d := first.Diagnostic; !d.FormatValid || !d.Allowlisted || d.CacheHit || d.Attempts != 1 || d.StatusCode != http.StatusOK || !d.Valid {
+		t.Fatalf("first diagnostic = %+v, want one allowlisted attempt answered 200", *d)
+	}
+	w = doRequest(t, r, http.MethodPost, "/payments?debug=true", createPaymentBody(orderID, 10, "pix"))
+	if d := decodeJSON[debugResponse](t, w).Diagnostic; d == nil || !d.CacheHit || d.Attempts != 0 {
+		t.Fatalf("second diagnostic = %+v, want a cache hit", d)
+	}
+
+	w = doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"))
+	if strings.Contains(w.Body.String(), "diagnostic") {
+		t.Fatalf("create without debug = %s, want no diagnostic", w.Body.String())
+	}
+	w = doRequest(t, r, http.MethodPost, "/payments?debug=true", createPaymentBody("not-a-uuid", 10, "pix"))
+	failed := decodeJSON[debugResponse](t, w)
+	if w.Code != http.StatusBadRequest || failed.Diagnostic == nil || failed.Diagnostic.FormatValid {
+		t.Fatalf("debug create for a malformed order = %d %s, want 400 with a diagnostic", w.Code, w.Body.String())
+	}
+}
+
+// A clock that moves backwards expires cached validations instead of
+// keeping them alive.
+func TestOrderCacheExpiresWhenClockMovesBackwards(t *testing.T) {
+	newTestRouter(t)
+	var calls atomic.Int32
+	newOrderService(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		ordersFound(w, r)
+	})
+	advance := useFakeClock(t)
+	resetState() // stamp the cache sweep with the fake clock
+	orderID := uuid.NewString()
+
+	validateOrder(orderID)
+	advance(cacheExpiry / 2)
+	if !traceOrderValidation(context.Background(), orderID).CacheHit {
+		t.Fatal("validation within the expiry missed the cache")
+	}
+	advance(-cacheExpiry)
+	if trace := traceOrderValidation(context.Background(), orderID); trace.CacheHit || !trace.Valid {
+		t.Fatalf("validation after the clock moved back = %+v, want a fresh lookup", trace)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("order-service called %d times, want 2", calls.Load())
+	}
+}
+
+// Validating an order caches its details along with its validity.
+func TestOrderDetailsAreCached(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		if path.Base(req.URL.Path) == "8f0a3c1e-6a55-4c7e-9d2b-1f3e5a7c9b0d" {
+			io.WriteString(w, "not json")
+			return
+		}
+		fmt.Fprintf(w, `{"id":%q,"status":"confirmed","total_amount":42.5,"currency":"EUR","user_id":"u-7"}`, path.Base(req.URL.Path))
+	})
+	orderID := uuid.NewString()
+
+	mustCreatePayment(t, r, orderID, 42.5, "pix")
+	info, exists := cachedOrder(orderID)
+	want := orderInfo{Valid: true, TotalAmount: 42.5, Currency: "EUR", Status: "confirmed", UserID: "u-7"}
+	info.CachedAt = time.Time{}
+	if !exists || info != want {
+		t.Fatalf("cached order = %+v (%v), want %+v", info, exists, want)
+	}
+
+	mustCreatePayment(t, r, "8f0a3c1e-6a55-4c7e-9d2b-1f3e5a7c9b0d", 10, "pix")
+	if info, exists := cachedOrder("8f0a3c1e-6a55-4c7e-9d2b-1f3e5a7c9b0d"); !exists || !info.Valid || info.TotalAmount != 0 {
+		t.Fatalf("cached order with an unreadable body = %+v (%v), want valid without details", info, exists)
+	}
+}
+
+// An order's payment_outcome decides how its payments process; values other
+// than completed and failed are ignored.
+func TestOrderDictatesPaymentOutcome(t *testing.T) {
+	r := newTestRouter(t)
+	outcomes := map[string]string{}
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		orderID := path.Base(req.URL.Path)
+		fmt.Fprintf(w, `{"id":%q,"status":"pending","payment_outcome":%q}`, orderID, outcomes[orderID])
+	})
+	failing, odd := uuid.NewString(), uuid.NewString()
+	outcomes[failing] = "failed"
+	outcomes[odd] = "refunded"
+
+	payment := mustCreatePayment(t, r, failing, 10, "pix")
+	w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process", "", "If-Match", "*")
+	if got := decodeJSON[Payment](t, w).Status; got != "failed" {
+		t.Fatalf("payment for an order dictating failure is %s, want failed", got)
+	}
+	mustProcessPayment(t, r, mustCreatePayment(t, r, odd, 10, "pix").ID)
+}
+
+// Payments in a terminal state are not sent to the gateway again.
+func TestTerminalPaymentsAreNotReprocessed(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+
+	completed := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	refunded := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	doRequest(t, r, http.MethodPost, "/payments/"+refunded.ID+"/refund", "", "If-Match", "*")
+	failed := mustCreatePayment(t, r, uuid.NewString(), 5000, "pix")
+	doRequest(t, r, http.MethodPost, "/payments/"+failed.ID+"/process", "", "If-Match", "*")
+	cancelled := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	doRequest(t, r, http.MethodPost, "/payments/"+cancelled.ID+"/cancel", "")
+
+	for status, id := range map[string]string{"completed": completed.ID, "refunded": refunded.ID, "failed": failed.ID, "cancelled": cancelled.ID} {
+		w := doRequest(t, r, http.MethodPost, "/payments/"+id+"/process", "", "If-Match", "*")
+		if w.Code != http.StatusConflict {
+			t.Errorf("processing a %s payment = %d %s, want 409", status, w.Code, w.Body.String())
+		}
+		if got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+id, "")).Status; got != status {
+			t.Errorf("payment is %s after the rejected process, want %s", got, status)
+		}
+	}
+}
+
+// listedStatuses lists GET target and counts the listed payments by status.
+func listedStatuses(t *testing.T, handler http.Handler, target string) map[string]int {
+	t.Helper()
+	w := doRequest(t, handler, http.MethodGet, target, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d %s, want 200", target, w.Code, w.Body.String())
+	}
+	counts := make(map[string]int)
+	for _, payment := range decodeJSON[paymentPage](t, w).Payments {
+		counts[payment.Status]++
+	}
+	return counts
+}
+
+func TestCreatedPaymentLocation(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"))
+	payment := decodeJSON[Payment](t, w)
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusCreated || location != "/payments/"+payment.ID {
+		t.Fatalf("create = %d with Location %q, want 201 with /payments/%s", w.Code, location, payment.ID)
+	}
+	if got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, location, "")); got.ID != payment.ID {
+		t.Fatalf("GET %s = payment %q, want the created one", location, got.ID)
+	}
+
+	w = doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "banana"))
+	if w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" {
+		t.Fatalf("rejected create = %d with Location %q, want 400 without one", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestListPaymentsByOrder(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	orderID := uuid.NewString()
+	var kept []Payment
+	for i := 0; i < 3; i++ {
+		kept = append(kept, mustCreatePayment(t, r, orderID, 10, "pix"))
+	}
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	if w := doRequest(t, r, http.MethodDelete, "/payments/"+kept[0].ID, "", "If-Match", "*"); w.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d %s, want 204", w.Code, w.Body.String())
+	}
+	kept = kept[1:]
+
+	page := decodeJSON[paymentPage](t, doRequest(t, r, http.MethodGet, "/payments?order_id="+orderID, ""))
+	want := map[string]bool{kept[0].ID: true, kept[1].ID: true}
+	if page.Total != len(want) || len(page.Payments) != len(want) {
+		t.Fatalf("payments for the order = %+v, want %d", page.Payments, len(want))
+	}
+	for _, payment := range page.Payments {
+		if !want[payment.ID] {
+			t.Errorf("listed payment %s, which is not one of the order's remaining payments", payment.ID)
+		}
+	}
+
+	if page := decodeJSON[paymentPage](t, doRequest(t, r, http.MethodGet, "/payments?order_id="+uuid.NewString(), "")); page.Total != 0 {
+		t.Errorf("payments for an order without any = %d, want 0", page.Total)
+	}
+	if w := doRequest(t, r, http.MethodGet, "/payments?order_id=not%20an%20order", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("list with a malformed order_id = %d, want 400", w.Code)
+	}
+}
+
+func TestListPaymentsByStatuses(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	cancelled := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	doRequest(t, r, http.MethodPost, "/payments/"+cancelled.ID+"/cancel", "")
+
+	got := listedStatuses(t, r, "/payments?status=pending,%20completed")
+	if len(got) != 2 || got["pending"] != 1 || got["completed"] != 1 {
+		t.Fatalf("payments listed by status = %v, want one pending and one completed", got)
+	}
+	if got := listedStatuses(t, r, "/payments?status=cancelled"); len(got) != 1 || got["cancelled"] != 1 {
+		t.Fatalf("cancelled payments listed = %v, want the one cancelled", got)
+	}
+	if w := doRequest(t, r, http.MethodGet, "/payments?status=pending,lost", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("GET with an unknown status = %d, want 400", w.Code)
+	}
+}
+
+func TestListPaymentsMaxAge(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+	setVar(t, &listMaxAge, time.Hour)
+
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	advance(2 * time.Hour)
+	recent := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+
+	page := decodeJSON[paymentPage](t, doRequest(t, r, http.MethodGet, "/payments", ""))
+	if page.Total != 1 || page.Payments[0].ID != recent.ID {
+		t.Fatalf("GET /payments = %+v, want only the payment from the last hour", page)
+	}
+	if page := decodeJSON[paymentPage](t, doRequest(t, r, http.MethodGet, "/payments?include_all=true", "")); page.Total != 2 {
+		t.Fatalf("GET /payments?include_all=true listed %d payments, want 2", page.Total)
+	}
+}
+
+// A caller that goes away mid-processing leaves the payment as it was,
+// and nobody else can process it while it is in progress.
+func TestCancelledProcessingLeavesPaymentPending(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &processingDelay, 200*time.Millisecond)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	target := "/payments/" + payment.ID + "/process"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupted := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, target, nil).WithContext(ctx)
+		req.Header.Set("If-Match", "*")
+		r.ServeHTTP(w, req)
+		interrupted <- w
+	}()
+	for decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+payment.ID, "")).Status != "processing" {
+		time.Sleep(time.Millisecond)
+	}
+	if w := doRequest(t, r, http.MethodPost, target, "", "If-Match", "*"); w.Code != http.StatusConflict {
+		t.Fatalf("second process while processing = %d, want 409", w.Code)
+	}
+	cancel()
+	if w := <-interrupted; w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("interrupted process = %d %s, want 503", w.Code, w.Body.String())
+	}
+	if got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+payment.ID, "")); got.Status != "pending" || got.ProcessedAt != nil {
+		t.Fatalf("payment after the interrupted process = %+v, want it pending", got)
+	}
+}
+
+// Pending payments can be deleted; completed ones are kept.
+func TestDeletePayment(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	pending := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	completed := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+
+	if w := doRequest(t, r, http.MethodDelete, "/payments/"+pending.ID, "", "If-Match", "*"); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE pending payment = %d %s, want 204", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodGet, "/payments/"+pending.ID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET deleted payment = %d, want 404", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodDelete, "/payments/"+pending.ID, "", "If-Match", "*"); w.Code != http.StatusNotFound {
+		t.Fatalf("DELETE twice = %d, want 404", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodDelete, "/payments/"+completed.ID, "", "If-Match", "*"); w.Code != http.StatusConflict {
+		t.Fatalf("DELETE completed payment = %d, want 409", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodGet, "/payments/"+completed.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("GET completed payment after the refused delete = %d, want 200", w.Code)
+	}
+}
+
+// An order-service that can't answer gets the caller a 503 with
+// Retry-After, and nothing is cached; a missing order is a plain 400.
+func TestInconclusiveValidationAsksForRetry(t *testing.T) {
+	r := newTestRouter(t)
+	setVar(t, &validationMaxAttempts, 1)
+	var failing atomic.Bool
+	failing.Store(true)
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		if failing.Load() {
+			http.Error(w, `{"error":"down"}`, http.StatusBadGateway)
+			return
+		}
+		ordersFound(w, req)
+	})
+	orderID := uuid.NewString()
+
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(orderID, 10, "pix"))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("create while the order-service fails = %d %s, want 503 with Retry-After", w.Code, w.Body.String())
+	}
+	if _, cached := cachedOrder(orderID); cached {
+		t.Fatal("inconclusive validation was cached")
+	}
+	failing.Store(false)
+	mustCreatePayment(t, r, orderID, 10, "pix")
+
+	newOrderService(t, ordersMissing)
+	w = doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"))
+	if w.Code != http.StatusBadRequest || w.Header().Get("Retry-After") != "" {
+		t.Fatalf("create for a missing order = %d, Retry-After %q; want 400 without it", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestListPaymentsPagination(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+	var created []Payment
+	for i := 0; i < 5; i++ {
+		created = append(created, mustCreatePayment(t, r, uuid.NewString(), 10, "pix"))
+		advance(time.Second)
+	}
+
+	page := decodeJSON[paymentPage](t, doRequest(t, r, http.MethodGet, "/payments?limit=2&offset=1", ""))
+	if page.Total != 5 || page.Limit != 2 || page.Offset != 1 || len(page.Payments) != 2 {
+		t.Fatalf("page = %+v, want 2 of 5 payments from offset 1", page)
+	}
+	if page.Payments[0].ID != created[3].ID || page.Payments[1].ID != created[2].ID {
+		t.Fatalf("page lists %s, %s; want the second and third newest", page.Payments[0].ID, page.Payments[1].ID)
+	}
+	if page := decodeJSON[paymentPage](t, doRequest(t, r, http.MethodGet, "/payments?offset=10", "")); page.Total != 5 || len(page.Payments) != 0 {
+		t.Fatalf("page past the end = %+v, want no payments", page)
+	}
+	for _, query := range []string{"limit=-1", "limit=501", "offset=-1", "limit=ten"} {
+		if w := doRequest(t, r, http.MethodGet, "/payments?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /payments?%s = %d, want 400", query, w.Code)
+		}
+	}
+}
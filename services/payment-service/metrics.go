@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	orderValidationRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payment_order_validation_retries_total",
+		Help: "Order validation attempts made after the first one.",
+	})
+	orderValidationRetrySuccesses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payment_order_validation_retry_successes_total",
+		Help: "Order validations that got a definitive answer only after retrying.",
+	})
+	orderValidationBatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payment_order_validation_batches_total",
+		Help: "Batch requests that validated several orders at once.",
+	})
+	orderValidationBackoffSeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payment_order_validation_backoff_seconds_total",
+		Help: "Total time spent sleeping between order validation attempts.",
+	})
+	orderValidationRetryBudget = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "payment_order_validation_retry_budget_tokens",
+		Help: "Retries left in the budget shared by all order validations.",
+	}, retryBudgetRemaining)
+	orderServiceTTFB = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "payment_order_service_ttfb_seconds",
+		Help:    "Time to first response byte from the order-service.",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 1.5},
+	})
+	orderValidationCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payment_order_validation_cache_hits_total",
+		Help: "Order validations answered from the cache.",
+	})
+	orderValidationCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payment_order_validation_cache_misses_total",
+		Help: "Order validations that had to ask the order-service.",
+	})
+	orderValidationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "payment_order_validation_duration_seconds",
+		Help:    "Time to validate an order, cache hits and retries included.",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	})
+	paymentsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payment_created_total",
+		Help: "Payments created.",
+	})
+	paymentRefunds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payment_refunds_total",
+		Help: "Refunds issued, partial refunds counted individually.",
+	})
+	orderBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "payment_order_service_breaker_state",
+		Help: "Order-service circuit breaker state: 0 closed, 1 half-open, 2 open.",
+	})
+	paymentsProcessing = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "payment_processing_in_flight",
+		Help: "Payments currently being processed.",
+	})
+	paymentsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "payment_processed_total",
+		Help: "Payments that finished processing, by resulting status.",
+	}, []string{"status"})
+	rateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payment_rate_limited_total",
+		Help: "Write requests rejected with 429 by the per-client rate limiter.",
+	})
+	paymentsExpired = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payment_expired_total",
+		Help: "Pending payments expired after PENDING_PAYMENT_TTL.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		orderValidationRetries,
+		orderValidationRetrySuccesses,
+		orderValidationBatches,
+		orderValidationBackoffSeconds,
+		orderValidationRetryBudget,
+		orderServiceTTFB,
+		orderValidationCacheHits,
+		orderValidationCacheMisses,
+		orderValidationDuration,
+		orderBreakerState,
+		paymentsCreated,
+		paymentRefunds,
+		paymentsProcessing,
+		paymentsProcessed,
+		rateLimited,
+		paymentsExpired,
+	)
+}
+
+// metricsHandler serves the default registry, negotiating the format from
+// the Accept header: Prometheus text by default, OpenMetrics when asked for.
+// OpenMetrics output carries the # EOF trailer and any exemplars attached to
+// counters and histograms, which the plain text format drops.
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			EnableOpenMetrics: true,
+		}),
+	)
+}
+
+// backoff sleeps for the given delay, or until ctx is done, and records
+// the time slept as retry backoff. It reports whether the full delay passed.
+func backoff(ctx context.Context, delay time.Duration) bool {
+	start := time.Now()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	defer func() {
+		orderValidationBackoffSeconds.Add(time.Since(start).Seconds())
+	}()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValidationRetryMetrics(t *testing.T) {
+	r := newTestRouter(t)
+	setVar(t, &validationMaxAttempts, 3)
+	setVar(t, &validationRetryBaseDelay, time.Millisecond)
+	var calls atomic.Int32
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, `{"error":"warming up"}`, http.StatusServiceUnavailable)
+			return
+		}
+		ordersFound(w, req)
+	})
+	retries := testutil.ToFloat64(orderValidationRetries)
+	successes := testutil.ToFloat64(orderValidationRetrySuccesses)
+	backoffSeconds := testutil.ToFloat64(orderValidationBackoffSeconds)
+
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	if got := testutil.ToFloat64(orderValidationRetries) - retries; got != 2 {
+		t.Errorf("retries counted = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(orderValidationRetrySuccesses) - successes; got != 1 {
+		t.Errorf("retry successes counted = %v, want 1", got)
+	}
+	if testutil.ToFloat64(orderValidationBackoffSeconds) <= backoffSeconds {
+		t.Errorf("backoff seconds did not grow")
+	}
+}
+
+func TestMetricsFormatNegotiation(t *testing.T) {
+	r := newTestRouter(t)
+
+	w := doRequest(t, r, http.MethodGet, "/metrics", "")
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") || strings.Contains(w.Body.String(), "# EOF") {
+		t.Fatalf("default metrics Content-Type = %q, want Prometheus text without an EOF trailer", w.Header().Get("Content-Type"))
+	}
+	w = doRequest(t, r, http.MethodGet, "/metrics", "", "Accept", "application/openmetrics-text; version=1.0.0")
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/openmetrics-text") || !strings.HasSuffix(w.Body.String(), "# EOF\n") {
+		t.Fatalf("OpenMetrics Content-Type = %q, want OpenMetrics ending in # EOF", w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "payment_created_total") {
+		t.Fatal("OpenMetrics output is missing payment_created_total")
+	}
+}
+
+func TestProcessingConcurrencyMetrics(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &processingDelay, 100*time.Millisecond)
+	completed := testutil.ToFloat64(paymentsProcessed.WithLabelValues("completed"))
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+
+	processed := make(chan int, 1)
+	go func() {
+		processed <- doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process", "", "If-Match", "*").Code
+	}()
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(paymentsProcessing) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("in-flight gauge never reached 1 while processing")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if code := <-processed; code != http.StatusOK {
+		t.Fatalf("process = %d, want 200", code)
+	}
+
+	if got := testutil.ToFloat64(paymentsProcessing); got != 0 {
+		t.Errorf("in-flight gauge after processing = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(paymentsProcessed.WithLabelValues("completed")) - completed; got != 1 {
+		t.Errorf("completed payments counted = %v, want 1", got)
+	}
+}
+
+func TestPaymentLifecycleMetrics(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	created := testutil.ToFloat64(paymentsCreated)
+	refunds := testutil.ToFloat64(paymentRefunds)
+	hits := testutil.ToFloat64(orderValidationCacheHits)
+	misses := testutil.ToFloat64(orderValidationCacheMisses)
+	orderID := uuid.NewString()
+
+	mustCreatePayment(t, r, orderID, 10, "pix")
+	payment := mustProcessPayment(t, r, mustCreatePayment(t, r, orderID, 10, "pix").ID)
+	doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/refund", `{"amount":4}`, "If-Match", "*")
+	doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/refund", "", "If-Match", "*")
+
+	deltas := map[string]float64{
+		"created":      testutil.ToFloat64(paymentsCreated) - created,
+		"refunds":      testutil.ToFloat64(paymentRefunds) - refunds,
+		"cache hits":   testutil.ToFloat64(orderValidationCacheHits) - hits,
+		"cache misses": testutil.ToFloat64(orderValidationCacheMisses) - misses,
+	}
+	want := map[string]float64{"created": 2, "refunds": 2, "cache hits": 1, "cache misses": 1}
+	for name, got := range deltas {
+		if got != want[name] {
+			t.Errorf("%s counted %v, want %v", name, got, want[name])
+		}
+	}
+}
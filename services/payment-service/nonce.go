@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	nonceEnforce = false
+	nonceTTL     = 5 * time.Minute
+	// Nonces seen within nonceTTL, keyed by value with the time first seen
+	seenNonces     = make(map[string]time.Time)
+	nonceMutex     = sync.Mutex{}
+	lastNoncePrune = time.Now()
+)
+
+// recordNonce stores a nonce and reports whether it was unused within the
+// replay window.
+func recordNonce(nonce string) bool {
+	nonceMutex.Lock()
+	defer nonceMutex.Unlock()
+
+	current := clock()
+	if current.Sub(lastNoncePrune) > nonceTTL {
+		for value, seenAt := range seenNonces {
+			if current.Sub(seenAt) > nonceTTL {
+				delete(seenNonces, value)
+			}
+		}
+		lastNoncePrune = current
+	}
+
+	if seenAt, exists := seenNonces[nonce]; exists && current.Sub(seenAt) <= nonceTTL {
+		return false
+	}
+	seenNonces[nonce] = current
+	return true
+}
+
+// nonceMiddleware rejects mutating requests that omit X-Nonce or reuse one
+// inside the replay window. It is a no-op unless NONCE_ENFORCE is set.
+func nonceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !nonceEnforce || c.Request.Method == "GET" || c.Request.URL.Path == "/health" {
+			c.Next()
+			return
+		}
+
+		nonce := c.GetHeader("X-Nonce")
+		if len(nonce) < 8 || len(nonce) > 128 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "X-Nonce header must be 8-128 characters"})
+			return
+		}
+		if !recordNonce(nonce) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Nonce has already been used"})
+			return
+		}
+		c.Next()
+	}
+}
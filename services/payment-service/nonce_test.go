@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNonceReplayProtection(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+	setVar(t, &nonceEnforce, true)
+	create := func(headers ...string) int {
+		return doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"), headers...).Code
+	}
+
+	if code := create(); code != http.StatusBadRequest {
+		t.Fatalf("create without a nonce = %d, want 400", code)
+	}
+	if code := create("X-Nonce", "nonce-0001"); code != http.StatusCreated {
+		t.Fatalf("create with a fresh nonce = %d, want 201", code)
+	}
+	if code := create("X-Nonce", "nonce-0001"); code != http.StatusConflict {
+		t.Fatalf("create replaying the nonce = %d, want 409", code)
+	}
+	advance(nonceTTL + time.Second)
+	if code := create("X-Nonce", "nonce-0001"); code != http.StatusCreated {
+		t.Fatalf("create reusing the nonce after the window = %d, want 201", code)
+	}
+	if w := doRequest(t, r, http.MethodGet, "/payments", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET without a nonce = %d, want 200", w.Code)
+	}
+}
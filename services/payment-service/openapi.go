@@ -0,0 +1,488 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// paymentPage is the response of GET /payments.
+type paymentPage struct {
+	Payments []Payment `json:"payments"`
+	Total    int       `json:"total"`
+	Limit    int       `json:"limit"`
+	Offset   int       `json:"offset"`
+}
+
+// errorResponse is the shape of every error body. Validator is only set
+// when a PAYMENT_VALIDATORS check rejected the request.
+type errorResponse struct {
+	Error     string `json:"error"`
+	Validator string `json:"validator,omitempty"`
+}
+
+// openAPIComponents are the structs published as named schemas. Request
+// schemas take required fields from binding tags, response schemas from
+// fields that are never omitted.
+var openAPIComponents = []struct {
+	name    string
+	value   any
+	request bool
+}{
+	{"Payment", Payment{}, false},
+	{"PaymentPage", paymentPage{}, false},
+	{"CreatePaymentRequest", CreatePaymentRequest{}, true},
+	{"RefundRequest", RefundRequest{}, true},
+	{"CancelRequest", CancelRequest{}, true},
+	{"WebhookVerifyRequest", WebhookVerifyRequest{}, true},
+	{"ProcessingJob", ProcessingJob{}, false},
+	{"OrderPayments", orderPayments{}, false},
+	{"BulkResult", bulkResult{}, false},
+	{"PaymentStats", paymentStats{}, false},
+	{"Error", errorResponse{}, false},
+}
+
+var (
+	amountType = reflect.TypeOf(Amount(0))
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+// schemaRef returns a $ref to a component when t is one, else nil.
+func schemaRef(t reflect.Type) map[string]any {
+	for _, component := range openAPIComponents {
+		if reflect.TypeOf(component.value) == t {
+			return map[string]any{"$ref": "#/components/schemas/" + component.name}
+		}
+	}
+	return nil
+}
+
+// typeSchema describes a Go type as an OpenAPI schema, following the same
+// json tags encoding/json uses.
+func typeSchema(t reflect.Type) map[string]any {
+	switch {
+	case t == amountType:
+		return map[string]any{"type": "number", "format": "double", "description": "Decimal amount; a string such as \"10.50\" is also accepted and returned when AMOUNT_AS_STRING is set"}
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	if ref := schemaRef(t); ref != nil {
+		return ref
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := typeSchema(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t, false)
+	}
+	return map[string]any{}
+}
+
+// structSchema describes the exported, json-visible fields of a struct.
+func structSchema(t reflect.Type, request bool) map[string]any {
+	properties := make(map[string]any)
+	required := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+
+		if request {
+			if strings.Contains(field.Tag.Get("binding"), "required") {
+				required = append(required, name)
+			}
+		} else if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// jsonContent wraps a schema as an application/json media type.
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+func componentRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// apiResponses builds a responses object. Each code maps to a component
+// name, or "" for an error body; 204 and 304 have no body.
+func apiResponses(codes map[int]string) map[string]any {
+	responses := make(map[string]any)
+	for code, component := range codes {
+		response := map[string]any{"description": http.StatusText(code)}
+		switch {
+		case code == http.StatusNoContent, code == http.StatusNotModified:
+		case component == "":
+			response["content"] = jsonContent(componentRef("Error"))
+		case strings.HasPrefix(component, "[]"):
+			response["content"] = jsonContent(map[string]any{"type": "array", "items": componentRef(component[2:])})
+		case component == "object":
+			response["content"] = jsonContent(map[string]any{"type": "object"})
+		default:
+			response["content"] = jsonContent(componentRef(component))
+		}
+		if code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable {
+			response["headers"] = map[string]any{
+				"Retry-After": map[string]any{"description": "Seconds to wait before retrying", "schema": map[string]any{"type": "integer"}},
+			}
+		}
+		if code == http.StatusTooManyRequests {
+			headers := response["headers"].(map[string]any)
+			headers["X-RateLimit-Limit"] = map[string]any{"description": "Requests the client may make at once", "schema": map[string]any{"type": "integer"}}
+			headers["X-RateLimit-Remaining"] = map[string]any{"description": "Requests left in the client's bucket", "schema": map[string]any{"type": "integer"}}
+			headers["X-RateLimit-Reset"] = map[string]any{"description": "Seconds until the bucket is full again", "schema": map[string]any{"type": "integer"}}
+		}
+		if code == http.StatusCreated {
+			response["headers"] = map[string]any{
+				"Location": map[string]any{"description": "Path of the created resource", "schema": map[string]any{"type": "string"}},
+			}
+		}
+		responses[strconv.Itoa(code)] = response
+	}
+	return responses
+}
+
+func pathParam(name, description string) map[string]any {
+	return map[string]any{"name": name, "in": "path", "required": true, "description": description, "schema": map[string]any{"type": "string"}}
+}
+
+func queryParam(name, description, typ string) map[string]any {
+	return map[string]any{"name": name, "in": "query", "description": description, "schema": map[string]any{"type": typ}}
+}
+
+// buildOpenAPISpec assembles the OpenAPI 3.0 document for the payment API.
+func buildOpenAPISpec() map[string]any {
+	schemas := make(map[string]any)
+	for _, component := range openAPIComponents {
+		schemas[component.name] = structSchema(reflect.TypeOf(component.value), component.request)
+	}
+	// The accepted methods are configuration, not part of the struct
+	schemas["CreatePaymentRequest"].(map[string]any)["properties"].(map[string]any)["method"].(map[string]any)["enum"] = paymentMethods
+
+	paymentID := pathParam("payment_id", "Payment ID")
+	ifMatch := map[string]any{"name": "If-Match", "in": "header", "description": "Payment version the change expects; 412 if it moved on", "schema": map[string]any{"type": "string"}}
+	requiredIfMatch := map[string]any{"name": "If-Match", "in": "header", "required": true, "description": "Payment version the change expects, or * for any; 412 if it moved on, 428 if missing", "schema": map[string]any{"type": "string"}}
+	ifNoneMatch := map[string]any{"name": "If-None-Match", "in": "header", "description": "ETag from an earlier GET; 304 while the payment is unchanged", "schema": map[string]any{"type": "string"}}
+	adminTokenHeader := map[string]any{"name": "X-Admin-Token", "in": "header", "required": true, "description": "The configured ADMIN_TOKEN", "schema": map[string]any{"type": "string"}}
+	rateLimited := http.StatusTooManyRequests
+	adminOnly := func(codes map[int]string) map[string]any {
+		codes[http.StatusUnauthorized] = ""
+		codes[http.StatusForbidden] = ""
+		return apiResponses(codes)
+	}
+
+	paths := map[string]any{
+		"/payments": map[string]any{
+			"post": map[string]any{
+				"summary": "Create a payment after validating its order",
+				"parameters": []any{
+					map[string]any{"name": "Idempotency-Key", "in": "header", "description": "Replays return the payment the key created", "schema": map[string]any{"type": "string", "maxLength": 128}},
+					queryParam("debug", "Include the order-validation diagnostic in errors", "boolean"),
+				},
+				"requestBody": map[string]any{"required": true, "content": jsonContent(componentRef("CreatePaymentRequest"))},
+				"responses": apiResponses(map[int]string{
+					http.StatusCreated: "Payment", http.StatusOK: "Payment", http.StatusBadRequest: "",
+					http.StatusNotFound: "", http.StatusConflict: "", rateLimited: "",
+					http.StatusInternalServerError: "", http.StatusServiceUnavailable: "",
+				}),
+			},
+			"get": map[string]any{
+				"summary": "List payments, newest first",
+				"parameters": []any{
+					queryParam("status", "Comma-separated statuses to include", "string"),
+					queryParam("order_id", "Only payments of this order", "string"),
+					queryParam("limit", "Page size (default 50, max 500)", "integer"),
+					queryParam("offset", "Payments to skip", "integer"),
+					queryParam("include_all", "Include payments older than LIST_MAX_AGE", "boolean"),
+				},
+				"responses": apiResponses(map[int]string{http.StatusOK: "PaymentPage", http.StatusBadRequest: ""}),
+			},
+			"delete": map[string]any{
+				"summary":    "Remove every payment, cached validation, Idempotency-Key and daily total (admin)",
+				"parameters": []any{adminTokenHeader},
+				"responses":  adminOnly(map[int]string{http.StatusOK: "object", http.StatusInternalServerError: "", rateLimited: ""}),
+			},
+		},
+		"/payments/batch": map[string]any{
+			"post": map[string]any{
+				"summary":    "Create up to BULK_MAX_ITEMS payments; 200 with created, failed and a BulkResult per item",
+				"parameters": []any{queryParam("stream", "Write each BulkResult as a line of NDJSON as soon as the item is done", "boolean")},
+				"requestBody": map[string]any{"required": true, "content": jsonContent(map[string]any{
+					"type": "array", "items": componentRef("CreatePaymentRequest"), "maxItems": maxBulkItems,
+				})},
+				"responses": apiResponses(map[int]string{
+					http.StatusOK: "object", http.StatusBadRequest: "", rateLimited: "", http.StatusServiceUnavailable: "",
+				}),
+			},
+		},
+		"/payments/recent": map[string]any{
+			"get": map[string]any{
+				"summary":    "Payments processed within the last minutes, most recently processed first",
+				"parameters": []any{queryParam("minutes", "How far back to look, 1-1440 (default 5)", "integer")},
+				"responses":  apiResponses(map[int]string{http.StatusOK: "[]Payment", http.StatusBadRequest: ""}),
+			},
+		},
+		"/payments/orders": map[string]any{
+			"get": map[string]any{
+				"summary":    "Distinct order IDs with payments; OrderPayments objects when counts=true",
+				"parameters": []any{queryParam("counts", "Return payment counts per order", "boolean")},
+				"responses":  apiResponses(map[int]string{http.StatusOK: "[]OrderPayments"}),
+			},
+		},
+		"/payments/outcomes-by-method": map[string]any{
+			"get": map[string]any{
+				"summary":   "Completed, failed and refunded counts and the success ratio, keyed by payment method",
+				"responses": apiResponses(map[int]string{http.StatusOK: "object"}),
+			},
+		},
+		"/payments/creation-rate": map[string]any{
+			"get": map[string]any{
+				"summary": "Payments created per bucket over a trailing window, oldest bucket first",
+				"parameters": []any{
+					queryParam("window", "Trailing window as a duration (default 5m)", "string"),
+					queryParam("bucket", "Bucket width as a duration that divides window (default 1m)", "string"),
+				},
+				"responses": apiResponses(map[int]string{http.StatusOK: "object", http.StatusBadRequest: ""}),
+			},
+		},
+		"/payments/stats": map[string]any{
+			"get": map[string]any{
+				"summary":    "Payment count, total and average amount, with counts by status and method",
+				"parameters": []any{queryParam("since", "Only payments created at or after this RFC3339 time", "string")},
+				"responses":  apiResponses(map[int]string{http.StatusOK: "PaymentStats", http.StatusBadRequest: ""}),
+			},
+		},
+		"/payments/batch/{batch_id}": map[string]any{
+			"get": map[string]any{
+				"summary":    "Payments in a batch with per-status counts",
+				"parameters": []any{pathParam("batch_id", "Batch ID")},
+				"responses":  apiResponses(map[int]string{http.StatusOK: "object", http.StatusBadRequest: "", http.StatusNotFound: ""}),
+			},
+		},
+		"/payments/{payment_id}": map[string]any{
+			"get": map[string]any{
+				"summary":    "Get a payment",
+				"parameters": []any{paymentID, ifNoneMatch},
+				"responses":  apiResponses(map[int]string{http.StatusOK: "Payment", http.StatusNotModified: "", http.StatusBadRequest: "", http.StatusNotFound: ""}),
+			},
+			"delete": map[string]any{
+				"summary":    "Delete a payment that is not completed or processing",
+				"parameters": []any{paymentID, requiredIfMatch},
+				"responses": apiResponses(map[int]string{
+					http.StatusNoContent: "", http.StatusBadRequest: "", http.StatusNotFound: "", http.StatusConflict: "", http.StatusPreconditionFailed: "", http.StatusPreconditionRequired: "", rateLimited: "",
+				}),
+			},
+		},
+		"/payments/{payment_id}/process": map[string]any{
+			"post": map[string]any{
+				"summary": "Process a payment; 202 with a Payment for async=true, or a ProcessingJob in queue mode",
+				"parameters": []any{
+					paymentID, requiredIfMatch,
+					queryParam("async", "Settle in the background and answer 202 at once", "boolean"),
+					queryParam("dry_run", "Only predict the outcome; the payment is not changed", "boolean"),
+				},
+				"responses": apiResponses(map[int]string{
+					http.StatusOK: "Payment", http.StatusAccepted: "Payment", http.StatusBadRequest: "",
+					http.StatusNotFound: "", http.StatusConflict: "", http.StatusPreconditionFailed: "", http.StatusPreconditionRequired: "", rateLimited: "",
+					http.StatusBadGateway: "", http.StatusServiceUnavailable: "",
+				}),
+			},
+		},
+		"/payments/{payment_id}/cancel": map[string]any{
+			"post": map[string]any{
+				"summary":     "Cancel a pending payment",
+				"parameters":  []any{paymentID, ifMatch},
+				"requestBody": map[string]any{"required": false, "content": jsonContent(componentRef("CancelRequest"))},
+				"responses": apiResponses(map[int]string{
+					http.StatusOK: "Payment", http.StatusBadRequest: "", http.StatusNotFound: "", http.StatusConflict: "", http.StatusPreconditionFailed: "", rateLimited: "",
+				}),
+			},
+		},
+		"/payments/{payment_id}/refund": map[string]any{
+			"post": map[string]any{
+				"summary": "Refund all or part of a completed payment",
+				"parameters": []any{
+					paymentID, requiredIfMatch,
+					map[string]any{"name": "Idempotency-Key", "in": "header", "description": "Replays return the payment instead of refunding it again", "schema": map[string]any{"type": "string", "maxLength": 128}},
+				},
+				"requestBody": map[string]any{"required": false, "content": jsonContent(componentRef("RefundRequest"))},
+				"responses": apiResponses(map[int]string{
+					http.StatusOK: "Payment", http.StatusBadRequest: "", http.StatusNotFound: "", http.StatusConflict: "", http.StatusPreconditionFailed: "", http.StatusPreconditionRequired: "", rateLimited: "",
+				}),
+			},
+		},
+		"/payments/{payment_id}/trace": map[string]any{
+			"get": map[string]any{
+				"summary":    "The payment's lifecycle as an OTLP/JSON trace",
+				"parameters": []any{paymentID},
+				"responses":  apiResponses(map[int]string{http.StatusOK: "object", http.StatusBadRequest: "", http.StatusNotFound: ""}),
+			},
+		},
+		"/orders/{order_id}/cancel-payments": map[string]any{
+			"post": map[string]any{
+				"summary":    "Cancel every pending payment of a cancelled order (admin)",
+				"parameters": []any{pathParam("order_id", "Order ID"), adminTokenHeader},
+				"responses":  adminOnly(map[int]string{http.StatusOK: "object", http.StatusBadRequest: "", rateLimited: ""}),
+			},
+		},
+		"/orders/{order_id}/refund": map[string]any{
+			"post": map[string]any{
+				"summary":    "Refund the remaining balance of every completed payment of an order (admin)",
+				"parameters": []any{pathParam("order_id", "Order ID"), adminTokenHeader},
+				"responses":  adminOnly(map[int]string{http.StatusOK: "object", http.StatusBadRequest: "", rateLimited: ""}),
+			},
+		},
+		"/webhooks/verify": map[string]any{
+			"post": map[string]any{
+				"summary":     "Check a webhook delivery's signature and timestamp as a receiver would (admin)",
+				"parameters":  []any{adminTokenHeader},
+				"requestBody": map[string]any{"required": true, "content": jsonContent(componentRef("WebhookVerifyRequest"))},
+				"responses":   adminOnly(map[int]string{http.StatusOK: "object", http.StatusBadRequest: "", http.StatusConflict: "", rateLimited: ""}),
+			},
+		},
+		"/jobs/{job_id}": map[string]any{
+			"get": map[string]any{
+				"summary":    "Progress of a queued processing job",
+				"parameters": []any{pathParam("job_id", "Job ID")},
+				"responses":  apiResponses(map[int]string{http.StatusOK: "ProcessingJob", http.StatusNotFound: ""}),
+			},
+		},
+		"/health": map[string]any{
+			"get": map[string]any{
+				"summary":   "Liveness, with the order-service circuit breaker state",
+				"responses": apiResponses(map[int]string{http.StatusOK: "object"}),
+			},
+		},
+		"/health/ready": map[string]any{
+			"get": map[string]any{
+				"summary":   "Readiness: 503 while draining or when the order-service is unreachable",
+				"responses": apiResponses(map[int]string{http.StatusOK: "object", http.StatusServiceUnavailable: "object"}),
+			},
+		},
+		"/openapi.json": map[string]any{
+			"get": map[string]any{
+				"summary":   "This document",
+				"responses": apiResponses(map[int]string{http.StatusOK: "object"}),
+			},
+		},
+		"/metrics": map[string]any{
+			"get": map[string]any{
+				"summary": "Prometheus metrics",
+				"responses": map[string]any{"200": map[string]any{
+					"description": http.StatusText(http.StatusOK),
+					"content":     map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}},
+				}},
+			},
+		},
+		"/admin/config": map[string]any{
+			"get": map[string]any{
+				"summary":    "Effective runtime configuration, secrets redacted (admin)",
+				"parameters": []any{adminTokenHeader},
+				"responses":  adminOnly(map[int]string{http.StatusOK: "object"}),
+			},
+		},
+		"/admin/drain": map[string]any{
+			"post": map[string]any{
+				"summary":    "Fail readiness and refuse new payments until undrained (admin)",
+				"parameters": []any{adminTokenHeader},
+				"responses":  adminOnly(map[int]string{http.StatusOK: "object", rateLimited: ""}),
+			},
+		},
+		"/admin/undrain": map[string]any{
+			"post": map[string]any{
+				"summary":    "Take the instance back into rotation (admin)",
+				"parameters": []any{adminTokenHeader},
+				"responses":  adminOnly(map[int]string{http.StatusOK: "object", rateLimited: ""}),
+			},
+		},
+		"/admin/order-cache": map[string]any{
+			"get": map[string]any{
+				"summary":    "Order-validation cache entries, newest first (admin)",
+				"parameters": []any{adminTokenHeader},
+				"responses":  adminOnly(map[int]string{http.StatusOK: "object"}),
+			},
+			"delete": map[string]any{
+				"summary":    "Drop every cached order validation (admin)",
+				"parameters": []any{adminTokenHeader},
+				"responses":  adminOnly(map[int]string{http.StatusOK: "object", rateLimited: ""}),
+			},
+		},
+		"/debug/dependencies/ttfb": map[string]any{
+			"get": map[string]any{
+				"summary":   "Recent order-service time-to-first-byte percentiles",
+				"responses": apiResponses(map[int]string{http.StatusOK: "object"}),
+			},
+		},
+	}
+	// Only registered in test mode
+	if testMode {
+		paths["/test/order-stubs/{order_id}"] = map[string]any{
+			"put": map[string]any{
+				"summary":     "Make validation of an order see the given order-service status",
+				"parameters":  []any{pathParam("order_id", "Order ID")},
+				"requestBody": map[string]any{"required": true, "content": jsonContent(map[string]any{"type": "object", "required": []string{"status"}, "properties": map[string]any{"status": map[string]any{"type": "integer"}}})},
+				"responses":   apiResponses(map[int]string{http.StatusOK: "object", http.StatusBadRequest: "", rateLimited: ""}),
+			},
+		}
+		paths["/test/order-stubs"] = map[string]any{
+			"delete": map[string]any{
+				"summary":   "Remove every order stub",
+				"responses": apiResponses(map[int]string{http.StatusNoContent: "", rateLimited: ""}),
+			},
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Payment Service API",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+// getOpenAPISpec serves the OpenAPI document, built from the request and
+// response structs so it tracks them.
+func getOpenAPISpec(c *gin.Context) {
+	c.JSON(http.StatusOK, buildOpenAPISpec())
+}
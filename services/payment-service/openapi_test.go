@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// specOperation returns the spec entry for a method and path, failing the
+// test when it is missing.
+func specOperation(t *testing.T, spec map[string]any, method, path string) map[string]any {
+	t.Helper()
+	item, ok := spec["paths"].(map[string]any)[path].(map[string]any)
+	if !ok {
+		t.Fatalf("%s is not in the OpenAPI spec", path)
+	}
+	operation, ok := item[method].(map[string]any)
+	if !ok {
+		t.Fatalf("%s %s is not in the OpenAPI spec", method, path)
+	}
+	return operation
+}
+
+func TestOpenAPIDocumentsPaymentStats(t *testing.T) {
+	r := newTestRouter(t)
+	spec := decodeJSON[map[string]any](t, doRequest(t, r, http.MethodGet, "/openapi.json", ""))
+
+	operation := specOperation(t, spec, "get", "/payments/stats")
+	ok := operation["responses"].(map[string]any)["200"].(map[string]any)
+	schema := ok["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+	if schema["$ref"] != "#/components/schemas/PaymentStats" {
+		t.Fatalf("200 schema = %v, want PaymentStats", schema)
+	}
+
+	stats := spec["components"].(map[string]any)["schemas"].(map[string]any)["PaymentStats"].(map[string]any)
+	properties := stats["properties"].(map[string]any)
+	for _, name := range []string{"count", "by_currency", "by_status", "by_method"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("PaymentStats has no %s property", name)
+		}
+	}
+}
+
+// specPath converts a gin route such as /payments/:payment_id to its
+// OpenAPI form, /payments/{payment_id}.
+func specPath(route string) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// The spec lists exactly the routes the server registers, in both modes.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	for _, test := range []bool{false, true} {
+		t.Run(fmt.Sprintf("test mode %v", test), func(t *testing.T) {
+			setVar(t, &testMode, test)
+			r := newTestRouter(t)
+			spec := decodeJSON[map[string]any](t, doRequest(t, r, http.MethodGet, "/openapi.json", ""))
+
+			registered := make(map[string]bool)
+			for _, route := range r.Routes() {
+				path, method := specPath(route.Path), strings.ToLower(route.Method)
+				registered[method+" "+path] = true
+				specOperation(t, spec, method, path)
+			}
+			for path, item := range spec["paths"].(map[string]any) {
+				for method := range item.(map[string]any) {
+					if !registered[method+" "+path] {
+						t.Errorf("spec documents %s %s, which the server does not serve", method, path)
+					}
+				}
+			}
+		})
+	}
+}
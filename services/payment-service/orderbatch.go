@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// How long order validations wait to be sent together to the
+	// order-service's POST /orders/batch (ORDER_BATCH_WINDOW, 0 disables)
+	orderBatchWindow = time.Duration(0)
+	// Most orders looked up by one batch request (ORDER_BATCH_MAX)
+	orderBatchMax = 100
+	// The batch being filled, sent when the window ends or it is full
+	openOrderBatch  *orderBatch
+	orderBatchMutex = sync.Mutex{}
+	// Set once the order-service answered that it has no batch endpoint
+	orderBatchUnsupported = false
+)
+
+// orderBatch is one POST /orders/batch request in the making. done is
+// closed once it has been sent and its answers cached.
+type orderBatch struct {
+	orderIDs []string
+	queued   map[string]bool
+	send     sync.Once
+	done     chan struct{}
+}
+
+// prefetchOrderValidations looks up the given orders in batches, caching
+// the answers so the per-order validation that follows is a cache hit. It
+// returns once every batch it joined has been answered, or ctx ends. Orders
+// a batch could not answer are left for per-order validation, which is
+// also what happens when batching is disabled or unsupported.
+func prefetchOrderValidations(ctx context.Context, orderIDs []string) {
+	if orderBatchWindow <= 0 {
+		return
+	}
+	waits := make(map[*orderBatch]bool)
+	orderBatchMutex.Lock()
+	if orderBatchUnsupported {
+		orderBatchMutex.Unlock()
+		return
+	}
+	for _, orderID := range orderIDs {
+		if !isValidOrderID(orderID) || orderStubbed(orderID) {
+			continue
+		}
+		if _, cached := cachedOrder(orderID); cached {
+			continue
+		}
+		if openOrderBatch == nil {
+			batch := &orderBatch{queued: make(map[string]bool), done: make(chan struct{})}
+			openOrderBatch = batch
+			time.AfterFunc(orderBatchWindow, func() { sendOrderBatch(batch) })
+		}
+		batch := openOrderBatch
+		if !batch.queued[orderID] {
+			batch.queued[orderID] = true
+			batch.orderIDs = append(batch.orderIDs, orderID)
+		}
+		waits[batch] = true
+		if len(batch.orderIDs) >= orderBatchMax {
+			openOrderBatch = nil
+			go sendOrderBatch(batch)
+		}
+	}
+	orderBatchMutex.Unlock()
+
+	for batch := range waits {
+		select {
+		case <-batch.done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendOrderBatch closes a batch to new orders and sends it, once however
+// many times it is called.
+func sendOrderBatch(batch *orderBatch) {
+	batch.send.Do(func() {
+		orderBatchMutex.Lock()
+		if openOrderBatch == batch {
+			openOrderBatch = nil
+		}
+		orderBatchMutex.Unlock()
+
+		fetchOrderBatch(batch.orderIDs)
+		close(batch.done)
+	})
+}
+
+// fetchOrderBatch asks the order-service about several orders at once and
+// caches the answers. The batch serves several callers, so it runs on its
+// own context. Any failure leaves the orders uncached; an order-service
+// without the endpoint is not asked again.
+func fetchOrderBatch(orderIDs []string) {
+	batchURL := orderServiceURL + "/orders/batch"
+	if !isAllowedURL(batchURL) || !orderBreaker.allow() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), validationTotalTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string][]string{"order_ids": orderIDs})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, batchURL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		orderBreaker.failure()
+		logger.Warn("order batch validation failed", "orders", len(orderIDs), "error", err.Error())
+		return
+	}
+	defer discardBody(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		orderBreaker.success()
+		orderBatchMutex.Lock()
+		orderBatchUnsupported = true
+		orderBatchMutex.Unlock()
+		logger.Info("order-service has no batch endpoint, validating orders one at a time",
+			"status_code", resp.StatusCode)
+		return
+	default:
+		if resp.StatusCode >= 500 {
+			orderBreaker.failure()
+		}
+		logger.Warn("order batch validation failed", "orders", len(orderIDs), "status_code", resp.StatusCode)
+		return
+	}
+	orderBreaker.success()
+
+	// Orders missing from the answer don't exist
+	var answer struct {
+		Orders map[string]json.RawMessage `json:"orders"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(&answer); err != nil || answer.Orders == nil {
+		logger.Warn("order batch validation returned an unreadable body", "orders", len(orderIDs))
+		return
+	}
+	for _, orderID := range orderIDs {
+		info := orderInfo{}
+		if order, found := answer.Orders[orderID]; found {
+			info = decodeOrderInfo(bytes.NewReader(order))
+		}
+		cacheOrderValidation(orderID, info)
+	}
+	orderValidationBatches.Inc()
+}
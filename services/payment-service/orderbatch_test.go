@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// batchOrderService is a fake order-service with a POST /orders/batch that
+// answers with batchStatus (200 to look the orders up), counting both the
+// batch and the single-order requests it gets.
+type batchOrderService struct {
+	mu      sync.Mutex
+	batches [][]string
+	singles int
+}
+
+func newBatchOrderService(t *testing.T, batchStatus int, missing ...string) *batchOrderService {
+	t.Helper()
+	service := &batchOrderService{}
+	newOrderService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orders/batch" {
+			service.mu.Lock()
+			service.singles++
+			service.mu.Unlock()
+			ordersFound(w, r)
+			return
+		}
+		var req struct {
+			OrderIDs []string `json:"order_ids"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		service.mu.Lock()
+		service.batches = append(service.batches, req.OrderIDs)
+		service.mu.Unlock()
+		if batchStatus != http.StatusOK {
+			w.WriteHeader(batchStatus)
+			return
+		}
+		orders := make(map[string]any)
+		for _, orderID := range req.OrderIDs {
+			orders[orderID] = map[string]string{"id": orderID, "status": "pending"}
+		}
+		for _, orderID := range missing {
+			delete(orders, orderID)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"orders": orders})
+	})
+	return service
+}
+
+func (s *batchOrderService) calls() (batches, singles int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches), s.singles
+}
+
+func bulkBody(orderIDs ...string) string {
+	items := make([]string, len(orderIDs))
+	for i, orderID := range orderIDs {
+		items[i] = createPaymentBody(orderID, 10, "pix")
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func TestBulkCreateBatchesOrderValidation(t *testing.T) {
+	r := newTestRouter(t)
+	setVar(t, &orderBatchWindow, 5*time.Millisecond)
+	missingOrder := uuid.NewString()
+	service := newBatchOrderService(t, http.StatusOK, missingOrder)
+	orderIDs := []string{uuid.NewString(), uuid.NewString(), uuid.NewString(), missingOrder}
+
+	w := doRequest(t, r, http.MethodPost, "/payments/batch", bulkBody(orderIDs...))
+	result := decodeJSON[struct {
+		Created int          `json:"created"`
+		Results []bulkResult `json:"results"`
+	}](t, w)
+	if w.Code != http.StatusOK || result.Created != 3 || result.Results[3].Status != http.StatusBadRequest {
+		t.Fatalf("bulk create = %d %s, want 3 created and the missing order rejected", w.Code, w.Body.String())
+	}
+	if batches, singles := service.calls(); batches != 1 || singles != 0 {
+		t.Fatalf("order-service got %d batch and %d single lookups, want one batch", batches, singles)
+	}
+	if got := service.batches[0]; len(got) != len(orderIDs) {
+		t.Fatalf("batch looked up %v, want all %d orders", got, len(orderIDs))
+	}
+}
+
+func TestBulkCreateBatchesAreCapped(t *testing.T) {
+	r := newTestRouter(t)
+	setVar(t, &orderBatchWindow, time.Minute)
+	setVar(t, &orderBatchMax, 2)
+	service := newBatchOrderService(t, http.StatusOK)
+
+	// A full batch goes out without waiting for the window
+	body := bulkBody(uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString())
+	if w := doRequest(t, r, http.MethodPost, "/payments/batch", body); w.Code != http.StatusOK {
+		t.Fatalf("bulk create = %d %s, want 200", w.Code, w.Body.String())
+	}
+	if batches, singles := service.calls(); batches != 2 || singles != 0 {
+		t.Fatalf("order-service got %d batch and %d single lookups, want two full batches", batches, singles)
+	}
+}
+
+func TestBulkCreateFallsBackWithoutBatchEndpoint(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusServiceUnavailable} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			r := newTestRouter(t)
+			setVar(t, &orderBatchWindow, 5*time.Millisecond)
+			service := newBatchOrderService(t, status)
+
+			for round := 1; round <= 2; round++ {
+				body := bulkBody(uuid.NewString(), uuid.NewString())
+				w := doRequest(t, r, http.MethodPost, "/payments/batch", body)
+				if w.Code != http.StatusOK || decodeJSON[struct {
+					Created int `json:"created"`
+				}](t, w).Created != 2 {
+					t.Fatalf("bulk create = %d %s, want both created by per-order validation", w.Code, w.Body.String())
+				}
+			}
+			// A missing endpoint is not asked again; a failing one is
+			wantBatches := 2
+			if status == http.StatusNotFound {
+				wantBatches = 1
+			}
+			if batches, singles := service.calls(); batches != wantBatches || singles != 4 {
+				t.Fatalf("order-service got %d batch and %d single lookups, want %d and 4", batches, singles, wantBatches)
+			}
+		})
+	}
+}
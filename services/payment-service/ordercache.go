@@ -0,0 +1,130 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Most entries the order-validation cache holds (ORDER_CACHE_MAX_ENTRIES,
+	// 0 for unbounded); beyond it the least recently used are evicted
+	orderCacheMaxEntries = 10000
+	// Order IDs from most (front) to least (back) recently used
+	orderCacheRecency  = list.New()
+	orderCacheElements = make(map[string]*list.Element)
+)
+
+// touchOrderCache marks an order's cache entry as just used. The caller
+// must hold cacheMutex for writing.
+func touchOrderCache(orderID string) {
+	if elem, exists := orderCacheElements[orderID]; exists {
+		orderCacheRecency.MoveToFront(elem)
+		return
+	}
+	orderCacheElements[orderID] = orderCacheRecency.PushFront(orderID)
+}
+
+// evictOrderCache drops least recently used entries until the cache is
+// within orderCacheMaxEntries. The caller must hold cacheMutex for writing.
+func evictOrderCache() {
+	if orderCacheMaxEntries <= 0 {
+		return
+	}
+	for orderCacheRecency.Len() > orderCacheMaxEntries {
+		oldest := orderCacheRecency.Back()
+		removeCachedOrder(oldest.Value.(string))
+	}
+}
+
+// forgetCachedOrder drops an order's cached validation, if any.
+func forgetCachedOrder(orderID string) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	removeCachedOrder(orderID)
+}
+
+// removeCachedOrder is forgetCachedOrder for callers already holding
+// cacheMutex for writing.
+func removeCachedOrder(orderID string) {
+	if elem, exists := orderCacheElements[orderID]; exists {
+		orderCacheRecency.Remove(elem)
+		delete(orderCacheElements, orderID)
+	}
+	delete(orderValidationCache, orderID)
+}
+
+// clearOrderCache drops every cached validation and reports how many there
+// were.
+func clearOrderCache() int {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	cleared := len(orderValidationCache)
+	orderValidationCache = make(map[string]orderInfo)
+	orderCacheRecency.Init()
+	orderCacheElements = make(map[string]*list.Element)
+	return cleared
+}
+
+// maxCacheEntriesListed caps the GET /admin/order-cache response.
+const maxCacheEntriesListed = 100
+
+// cacheEntry is the debug view of an order-validation cache entry. The
+// order's user ID is deliberately left out.
+type cacheEntry struct {
+	OrderID    string    `json:"order_id"`
+	Valid      bool      `json:"valid"`
+	Status     string    `json:"status,omitempty"`
+	Currency   string    `json:"currency,omitempty"`
+	CachedAt   time.Time `json:"cached_at"`
+	AgeSeconds float64   `json:"age_seconds"`
+	Expired    bool      `json:"expired"`
+}
+
+// getCacheEntries lists the order-validation cache, newest entries first,
+// with how long each has been cached. Expired entries are listed until the
+// next sweep removes them.
+func getCacheEntries(c *gin.Context) {
+	now := clock()
+
+	cacheMutex.RLock()
+	entries := make([]cacheEntry, 0, len(orderValidationCache))
+	for orderID, info := range orderValidationCache {
+		entries = append(entries, cacheEntry{
+			OrderID:    orderID,
+			Valid:      info.Valid,
+			Status:     info.Status,
+			Currency:   info.Currency,
+			CachedAt:   info.CachedAt,
+			AgeSeconds: now.Sub(info.CachedAt).Seconds(),
+			Expired:    orderEntryExpired(info, now),
+		})
+	}
+	nextSweep := cacheExpiry - now.Sub(lastCacheClean)
+	cacheMutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CachedAt.After(entries[j].CachedAt)
+	})
+	total := len(entries)
+	if len(entries) > maxCacheEntriesListed {
+		entries = entries[:maxCacheEntriesListed]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":            entries,
+		"total":              total,
+		"truncated":          total > len(entries),
+		"cache_expiry":       cacheExpiry.String(),
+		"next_sweep_seconds": nextSweep.Seconds(),
+	})
+}
+
+// flushOrderCache empties the order-validation cache so the next payment
+// for any order revalidates it, without waiting for cacheExpiry.
+func flushOrderCache(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"flushed": clearOrderCache()})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// cacheListing is the body of GET /admin/order-cache.
+type cacheListing struct {
+	Entries []cacheEntry `json:"entries"`
+	Total   int          `json:"total"`
+}
+
+func TestCacheEntriesListing(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	admin := useAdminToken(t)
+	advance := useFakeClock(t)
+	resetState()
+
+	older, newer := uuid.NewString(), uuid.NewString()
+	validateOrder(older)
+	advance(cacheExpiry)
+	validateOrder(newer)
+	advance(time.Second)
+
+	if w := doRequest(t, r, http.MethodGet, "/admin/order-cache", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET cache entries without the token = %d, want 401", w.Code)
+	}
+	w := doRequest(t, r, http.MethodGet, "/admin/order-cache", "", admin...)
+	listing := decodeJSON[cacheListing](t, w)
+	if listing.Total != 2 || listing.Entries[0].OrderID != newer || listing.Entries[1].OrderID != older {
+		t.Fatalf("cache entries = %+v, want both orders, newest first", listing)
+	}
+	if e := listing.Entries[0]; !e.Valid || e.Status != "pending" || e.AgeSeconds != 1 || e.Expired {
+		t.Errorf("newer entry = %+v, want valid, pending, a second old and fresh", e)
+	}
+	if e := listing.Entries[1]; !e.Expired {
+		t.Errorf("older entry = %+v, want it expired", e)
+	}
+	if strings.Contains(w.Body.String(), "user_id") {
+		t.Errorf("cache entries %s expose user IDs", w.Body.String())
+	}
+}
+
+// Beyond orderCacheMaxEntries the least recently used entry is evicted.
+func TestOrderCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	newTestRouter(t)
+	var calls atomic.Int32
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		ordersFound(w, req)
+	})
+	setVar(t, &orderCacheMaxEntries, 2)
+
+	first, second, third := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	validateOrder(first)
+	validateOrder(second)
+	validateOrder(first) // now the most recently used
+	validateOrder(third)
+
+	if _, cached := cachedOrder(second); cached {
+		t.Fatal("least recently used order is still cached")
+	}
+	for _, orderID := range []string{first, third} {
+		if _, cached := cachedOrder(orderID); !cached {
+			t.Fatalf("order %s was evicted, want it cached", orderID)
+		}
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("order-service called %d times, want 3", calls.Load())
+	}
+}
+
+// A sweep removes only the entries that outlived cacheExpiry.
+func TestOrderCacheEntriesExpireIndividually(t *testing.T) {
+	newTestRouter(t)
+	var calls atomic.Int32
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		ordersFound(w, req)
+	})
+	advance := useFakeClock(t)
+	resetState()
+
+	older, newer := uuid.NewString(), uuid.NewString()
+	validateOrder(older)
+	advance(cacheExpiry * 2 / 3)
+	validateOrder(newer)
+	advance(cacheExpiry / 2)
+	validateOrder(uuid.NewString()) // sweeps the cache
+
+	cacheMutex.RLock()
+	_, olderKept := orderValidationCache[older]
+	_, newerKept := orderValidationCache[newer]
+	cacheMutex.RUnlock()
+	if olderKept || !newerKept {
+		t.Fatalf("after the sweep older cached = %v, newer cached = %v; want only the newer", olderKept, newerKept)
+	}
+	validateOrder(newer)
+	if calls.Load() != 3 {
+		t.Fatalf("order-service called %d times, want 3", calls.Load())
+	}
+}
+
+func TestAdminOrderCacheFlush(t *testing.T) {
+	r := newTestRouter(t)
+	var calls atomic.Int32
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		ordersFound(w, req)
+	})
+	admin := useAdminToken(t)
+	orderID := uuid.NewString()
+	mustCreatePayment(t, r, orderID, 10, "pix")
+
+	listing := decodeJSON[cacheListing](t, doRequest(t, r, http.MethodGet, "/admin/order-cache", "", admin...))
+	if listing.Total != 1 || listing.Entries[0].OrderID != orderID || !listing.Entries[0].Valid {
+		t.Fatalf("admin order cache = %+v, want the validated order", listing)
+	}
+
+	if w := doRequest(t, r, http.MethodDelete, "/admin/order-cache", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("flush without the token = %d, want 401", w.Code)
+	}
+	w := doRequest(t, r, http.MethodDelete, "/admin/order-cache", "", admin...)
+	if w.Code != http.StatusOK || decodeJSON[struct {
+		Flushed int `json:"flushed"`
+	}](t, w).Flushed != 1 {
+		t.Fatalf("flush = %d %s, want one entry flushed", w.Code, w.Body.String())
+	}
+	if listing := decodeJSON[cacheListing](t, doRequest(t, r, http.MethodGet, "/admin/order-cache", "", admin...)); listing.Total != 0 {
+		t.Fatalf("admin order cache after flush = %+v, want it empty", listing)
+	}
+
+	mustCreatePayment(t, r, orderID, 10, "pix")
+	if calls.Load() != 2 {
+		t.Fatalf("order-service called %d times, want the flushed order revalidated", calls.Load())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Status codes the order-service should appear to return, by order ID.
+	// Only consulted in test mode.
+	orderStubs      = make(map[string]int)
+	orderStubsMutex = sync.RWMutex{}
+)
+
+// orderStubbed reports whether test mode has a stubbed status for an order.
+func orderStubbed(orderID string) bool {
+	if !testMode {
+		return false
+	}
+	orderStubsMutex.RLock()
+	defer orderStubsMutex.RUnlock()
+	_, stubbed := orderStubs[orderID]
+	return stubbed
+}
+
+// fetchOrder gets an order from the order-service, unless test mode has a
+// stubbed status for it, in which case that response is returned instead.
+func fetchOrder(ctx context.Context, orderID, orderURL string) (*http.Response, error) {
+	if testMode {
+		orderStubsMutex.RLock()
+		status, stubbed := orderStubs[orderID]
+		orderStubsMutex.RUnlock()
+		if stubbed {
+			return &http.Response{
+				StatusCode: status,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{}`)),
+			}, nil
+		}
+	}
+	return getOrderService(ctx, orderURL)
+}
+
+// setOrderStub makes validation of an order see the given status code,
+// e.g. {"status": 503}. Any cached validation of the order is dropped so
+// the stub takes effect immediately.
+func setOrderStub(c *gin.Context) {
+	var req struct {
+		Status int `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status < 100 || req.Status > 599 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be an HTTP status code"})
+		return
+	}
+
+	orderID := c.Param("order_id")
+	orderStubsMutex.Lock()
+	orderStubs[orderID] = req.Status
+	orderStubsMutex.Unlock()
+	forgetCachedOrder(orderID)
+
+	c.JSON(http.StatusOK, gin.H{"order_id": orderID, "status": req.Status})
+}
+
+// clearOrderStubs removes every stub so validation calls the real
+// order-service again.
+func clearOrderStubs(c *gin.Context) {
+	orderStubsMutex.Lock()
+	stubbed := orderStubs
+	orderStubs = make(map[string]int)
+	orderStubsMutex.Unlock()
+	for orderID := range stubbed {
+		forgetCachedOrder(orderID)
+	}
+	c.Status(http.StatusNoContent)
+}
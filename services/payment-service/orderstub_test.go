@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestOrderStubs(t *testing.T) {
+	setVar(t, &testMode, true)
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	orderID := uuid.NewString()
+	mustCreatePayment(t, r, orderID, 10, "pix") // caches the order as valid
+
+	stub := "/test/order-stubs/" + orderID
+	if w := doRequest(t, r, http.MethodPut, stub, `{"status":404}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT stub = %d %s, want 200", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(orderID, 10, "pix")); w.Code != http.StatusBadRequest {
+		t.Fatalf("create for an order stubbed as 404 = %d, want 400", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodPut, stub, `{"status":99}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT stub with a bogus status = %d, want 400", w.Code)
+	}
+
+	if w := doRequest(t, r, http.MethodDelete, "/test/order-stubs", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE stubs = %d, want 204", w.Code)
+	}
+	mustCreatePayment(t, r, orderID, 10, "pix")
+}
+
+func TestOrderStubsNeedTestMode(t *testing.T) {
+	r := newTestRouter(t)
+	if w := doRequest(t, r, http.MethodPut, "/test/order-stubs/"+uuid.NewString(), `{"status":404}`); w.Code != http.StatusNotFound {
+		t.Fatalf("PUT stub outside test mode = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Sustained write requests per second allowed per client IP (RATE_LIMIT_RPS, 0 disables)
+	rateLimitRPS = 0.0
+	// Requests a client may make at once before being limited (RATE_LIMIT_BURST)
+	rateLimitBurst = 10
+	// Proxies whose X-Forwarded-For names the client, as IPs or CIDRs
+	// (TRUSTED_PROXIES). None by default, so a client can't pick its own
+	// bucket by sending the header
+	trustedProxies   []string
+	rateLimitMutex   = sync.Mutex{}
+	rateLimitBuckets = make(map[string]*tokenBucket)
+	lastBucketSweep  = time.Now()
+)
+
+// tokenBucket refills at rateLimitRPS up to rateLimitBurst tokens.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill tops the bucket up for the time elapsed since it was last used.
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(rateLimitBurst), b.tokens+elapsed*rateLimitRPS)
+	}
+	b.last = now
+}
+
+// takeToken spends one token from the client's bucket. It returns the
+// whole tokens left and how long until the bucket is full again; when none
+// was left it also returns how long until the next one is available.
+func takeToken(client string, now time.Time) (allowed bool, remaining int, wait, reset time.Duration) {
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+
+	sweepTokenBuckets(now)
+	bucket, exists := rateLimitBuckets[client]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(rateLimitBurst), last: now}
+		rateLimitBuckets[client] = bucket
+	}
+	bucket.refill(now)
+	allowed = bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	} else {
+		wait = seconds((1 - bucket.tokens) / rateLimitRPS)
+	}
+	reset = seconds((float64(rateLimitBurst) - bucket.tokens) / rateLimitRPS)
+	return allowed, int(bucket.tokens), wait, reset
+}
+
+// seconds converts a fractional number of seconds to a time.Duration.
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+// ceilSeconds renders a duration as whole seconds, rounded up, for headers.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// sweepTokenBuckets drops buckets that have refilled completely, since a new
+// bucket would be identical. Caller must hold rateLimitMutex.
+func sweepTokenBuckets(now time.Time) {
+	if now.Sub(lastBucketSweep) < time.Minute {
+		return
+	}
+	lastBucketSweep = now
+	for client, bucket := range rateLimitBuckets {
+		bucket.refill(now)
+		if bucket.tokens >= float64(rateLimitBurst) {
+			delete(rateLimitBuckets, client)
+		}
+	}
+}
+
+// rateLimitExempt reports whether a path is never rate limited, so probes
+// and scrapes keep working while clients are throttled.
+func rateLimitExempt(path string) bool {
+	return path == "/metrics" || path == "/health" || strings.HasPrefix(path, "/health/")
+}
+
+// rateLimitMiddleware throttles write requests per client IP, answering
+// 429 with Retry-After once the client's bucket is empty. Every limited
+// request reports the bucket in X-RateLimit-Limit (the burst),
+// X-RateLimit-Remaining and X-RateLimit-Reset (seconds until it is full).
+func rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rateLimitRPS <= 0 || rateLimitBurst <= 0 || rateLimitExempt(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		allowed, remaining, wait, reset := takeToken(c.ClientIP(), clock())
+		c.Header("X-RateLimit-Limit", strconv.Itoa(rateLimitBurst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Reset", ceilSeconds(reset))
+		if !allowed {
+			rateLimited.Inc()
+			c.Header("Retry-After", ceilSeconds(wait))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded, retry later"})
+			return
+		}
+		c.Next()
+	}
+}
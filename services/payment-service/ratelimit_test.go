@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requestFrom serves a request that arrived from the client's address,
+// with headers as name/value pairs.
+func requestFrom(handler http.Handler, client, method, target string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader("{}"))
+	req.RemoteAddr = client + ":40000"
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitedWrites(t *testing.T) {
+	r := newTestRouter(t)
+	advance := useFakeClock(t)
+	setVar(t, &rateLimitRPS, 0.5)
+	setVar(t, &rateLimitBurst, 2)
+	post := func(client string) *httptest.ResponseRecorder {
+		return requestFrom(r, client, http.MethodPost, "/payments")
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := post("203.0.113.1"); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d within the burst = 429", i+1)
+		}
+	}
+	w := post("203.0.113.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("request past the burst = %d (Retry-After %q), want 429 with Retry-After 2", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := post("203.0.113.2"); w.Code == http.StatusTooManyRequests {
+		t.Fatalf("another client = 429, want its own bucket")
+	}
+	for _, target := range []string{"/health", "/metrics", "/payments"} {
+		if w := requestFrom(r, "203.0.113.1", http.MethodGet, target); w.Code == http.StatusTooManyRequests {
+			t.Errorf("GET %s by a throttled client = 429, want it exempt", target)
+		}
+	}
+
+	advance(2 * time.Second)
+	if w := post("203.0.113.1"); w.Code == http.StatusTooManyRequests {
+		t.Fatalf("request after Retry-After = 429, want a refilled token")
+	}
+}
+
+// A client can't get a fresh bucket by claiming another address in
+// X-Forwarded-For; only a trusted proxy's header is believed.
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	setVar(t, &rateLimitRPS, 0.5)
+	setVar(t, &rateLimitBurst, 1)
+	spoof := func(r http.Handler, client string, i int) *httptest.ResponseRecorder {
+		return requestFrom(r, client, http.MethodPost, "/payments", "X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+	}
+
+	r := newTestRouter(t)
+	useFakeClock(t)
+	spoof(r, "203.0.113.1", 1)
+	if w := spoof(r, "203.0.113.1", 2); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request with a new X-Forwarded-For = %d, want 429 from the sender's own bucket", w.Code)
+	}
+
+	setVar(t, &trustedProxies, []string{"10.0.0.0/8"})
+	r = newTestRouter(t)
+	spoof(r, "10.1.2.3", 1)
+	if w := spoof(r, "10.1.2.3", 2); w.Code == http.StatusTooManyRequests {
+		t.Fatalf("trusted proxy forwarding another client = 429, want that client's own bucket")
+	}
+	if w := spoof(r, "10.1.2.3", 2); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("trusted proxy forwarding the same client again = %d, want 429", w.Code)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	r := newTestRouter(t)
+	useFakeClock(t)
+	setVar(t, &rateLimitRPS, 0.5)
+	setVar(t, &rateLimitBurst, 3)
+
+	for i, want := range []string{"2", "1", "0", "0"} {
+		w := doRequest(t, r, http.MethodPost, "/payments", "{}")
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != want {
+			t.Fatalf("request %d: X-RateLimit-Remaining = %q, want %s", i+1, got, want)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Fatalf("request %d: X-RateLimit-Limit = %q, want 3", i+1, got)
+		}
+	}
+	// Three tokens spent at 0.5/s take six seconds to come back
+	if got := doRequest(t, r, http.MethodPost, "/payments", "{}").Header().Get("X-RateLimit-Reset"); got != "6" {
+		t.Fatalf("X-RateLimit-Reset of an empty bucket = %q, want 6", got)
+	}
+	if w := doRequest(t, r, http.MethodGet, "/payments", ""); w.Header().Get("X-RateLimit-Remaining") != "" {
+		t.Fatalf("GET /payments reports X-RateLimit-Remaining %q, want reads unlimited and unreported", w.Header().Get("X-RateLimit-Remaining"))
+	}
+}
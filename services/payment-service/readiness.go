@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	// Order-service host:port probed for readiness (READINESS_ORDER_HOST);
+	// empty means the ORDER_SERVICE_URL host
+	readinessOrderHost = ""
+	// How long the readiness dial may take (READINESS_TIMEOUT)
+	readinessTimeout = 500 * time.Millisecond
+	// How long a probe result is reused (READINESS_CACHE_TTL)
+	readinessCacheTTL = 5 * time.Second
+
+	readinessMutex   = sync.Mutex{}
+	readinessChecked time.Time
+	readinessErr     error
+)
+
+// readinessTarget is the order host readiness depends on.
+func readinessTarget() string {
+	if readinessOrderHost != "" {
+		return readinessOrderHost
+	}
+	if parsed, err := url.Parse(orderServiceURL); err == nil {
+		return parsed.Host
+	}
+	return ""
+}
+
+// checkOrderServiceReachable opens a TCP connection to the order host. The
+// result is cached for readinessCacheTTL and probes don't overlap, so
+// frequent readiness polls don't hammer the dependency.
+func checkOrderServiceReachable() (string, error) {
+	target := readinessTarget()
+	if target == "" {
+		return "", nil
+	}
+
+	readinessMutex.Lock()
+	defer readinessMutex.Unlock()
+	now := clock()
+	if !readinessChecked.IsZero() && now.Sub(readinessChecked) < readinessCacheTTL && now.Sub(readinessChecked) >= 0 {
+		return target, readinessErr
+	}
+
+	conn, err := net.DialTimeout("tcp", target, readinessTimeout)
+	if err == nil {
+		conn.Close()
+	}
+	readinessChecked, readinessErr = now, err
+	return target, err
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReadinessFollowsTheOrderService(t *testing.T) {
+	r := newTestRouter(t)
+	advance := useFakeClock(t)
+	orders := newOrderService(t, ordersFound)
+
+	if w := doRequest(t, r, http.MethodGet, "/health/ready", ""); w.Code != http.StatusOK {
+		t.Fatalf("readiness with the order-service up = %d %s, want 200", w.Code, w.Body.String())
+	}
+
+	orders.Close()
+	if w := doRequest(t, r, http.MethodGet, "/health/ready", ""); w.Code != http.StatusOK {
+		t.Fatalf("readiness within the cache TTL = %d, want the cached 200", w.Code)
+	}
+	advance(readinessCacheTTL + time.Second)
+	if w := doRequest(t, r, http.MethodGet, "/health/ready", ""); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readiness with the order-service down = %d %s, want 503", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodGet, "/health", ""); w.Code != http.StatusOK {
+		t.Fatalf("liveness with the order-service down = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RefundRequest is the optional body of POST /payments/:payment_id/refund.
+// Without an amount the whole remaining balance is refunded.
+type RefundRequest struct {
+	Amount *Amount `json:"amount"`
+}
+
+// refundPayment refunds all or part of a completed payment. The payment
+// moves to "refunded" on the first refund and keeps accepting partial
+// refunds until the cumulative RefundAmount reaches the original Amount.
+// A retry with the same Idempotency-Key returns the payment instead of
+// refunding it again.
+func refundPayment(c *gin.Context) {
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if idempotencyKey != "" && !isValidIdempotencyKey(idempotencyKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be 1-128 printable ASCII characters"})
+		return
+	}
+	expected, ok := requiredVersion(c)
+	if !ok {
+		return
+	}
+	var req RefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	paymentID := c.Param("payment_id")
+
+	var refundedID string
+	if idempotencyKey != "" {
+		idempotencyKey = idempotencyScope(idempotencyRefund, idempotencyKey)
+		fingerprint := requestFingerprint(struct {
+			PaymentID string
+			Request   RefundRequest
+		}{paymentID, req})
+		entry, owner, err := claimIdempotencyKey(idempotencyKey, fingerprint)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if !owner {
+			paymentsMutex.RLock()
+			defer paymentsMutex.RUnlock()
+			if payment, exists := payments.Get(entry.paymentID); exists {
+				c.JSON(http.StatusOK, payment)
+			} else {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Payment refunded with this Idempotency-Key no longer exists"})
+			}
+			return
+		}
+		defer func() { finishIdempotencyKey(idempotencyKey, entry, refundedID) }()
+	}
+
+	paymentsMutex.Lock()
+	defer paymentsMutex.Unlock()
+
+	payment, exists := payments.Get(paymentID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	if !versionMatches(payment, expected) {
+		preconditionFailed(c, payment.Version)
+		return
+	}
+
+	remaining := roundCents(float64(payment.Amount - payment.RefundAmount))
+	refundable := payment.Status == "completed" || (payment.Status == "refunded" && remaining > 0)
+	if !refundable {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Payment cannot be refunded in status %s", payment.Status)})
+		return
+	}
+
+	amount := remaining
+	if req.Amount != nil {
+		amount = roundCents(float64(*req.Amount))
+		if math.IsNaN(amount) || amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount must be greater than zero"})
+			return
+		}
+		if amount > remaining {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Refund amount %.2f exceeds the refundable balance of %.2f", amount, remaining)})
+			return
+		}
+	}
+
+	applyRefund(payment, amount)
+	refundedID = payment.ID
+	annotatePaymentSpan(c.Request.Context(), *payment)
+
+	c.JSON(http.StatusOK, payment)
+}
+
+// applyRefund records a refund of amount against payment. The caller
+// holds paymentsMutex and has checked amount against the balance.
+func applyRefund(payment *Payment, amount float64) {
+	now := clock()
+	payment.Status = "refunded"
+	payment.RefundAmount = Amount(roundCents(float64(payment.RefundAmount) + amount))
+	payment.RefundedAt = &now
+	persistPayment(payment)
+	releaseDailyTotal(payment, amount)
+	paymentRefunds.Inc()
+	notifyPaymentEvent(eventPaymentRefunded, *payment)
+}
+
+// orderRefund is the outcome for one payment of POST /orders/:order_id/refund.
+type orderRefund struct {
+	PaymentID string   `json:"payment_id"`
+	Refunded  Amount   `json:"refunded"`
+	Payment   *Payment `json:"payment"`
+}
+
+// refundOrderPayments refunds the remaining balance of every completed or
+// partially refunded payment of an order, e.g. after the order was
+// cancelled upstream. Fully refunded payments have nothing left and are
+// skipped, so repeating the call refunds nothing twice.
+func refundOrderPayments(c *gin.Context) {
+	orderID := c.Param("order_id")
+	if !isValidOrderID(orderID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	refunds := make([]orderRefund, 0)
+	paymentsMutex.Lock()
+	for _, payment := range payments.ListByOrder(orderID) {
+		remaining := roundCents(float64(payment.Amount - payment.RefundAmount))
+		refundable := payment.Status == "completed" || payment.Status == "refunded"
+		if !refundable || remaining <= 0 {
+			continue
+		}
+		applyRefund(payment, remaining)
+		refunds = append(refunds, orderRefund{PaymentID: payment.ID, Refunded: Amount(remaining), Payment: payment})
+	}
+	// Encoded under the lock; settlement may be updating the payments
+	c.JSON(http.StatusOK, gin.H{"order_id": orderID, "refunds": refunds})
+	paymentsMutex.Unlock()
+}
+
+// roundCents rounds to two decimal places so repeated partial refunds
+// don't accumulate float error.
+func roundCents(value float64) float64 {
+	return math.Round(value*100) / 100
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRefundAmountFollowsAmountFormat(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &amountsAsStrings, true)
+
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	mustProcessPayment(t, r, payment.ID)
+	w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/refund", `{"amount":"4"}`, "If-Match", "*")
+	if w.Code != http.StatusOK {
+		t.Fatalf("refund = %d %s, want 200", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"refund_amount":"4.00"`) {
+		t.Fatalf("refund response = %s, want refund_amount as a string", w.Body.String())
+	}
+}
+
+func TestPartialRefunds(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	pending := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	payment := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	refund := "/payments/" + payment.ID + "/refund"
+
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+pending.ID+"/refund", "", "If-Match", "*"); w.Code != http.StatusConflict {
+		t.Fatalf("refund of a pending payment = %d, want 409", w.Code)
+	}
+	for _, body := range []string{`{"amount":0}`, `{"amount":-1}`, `{"amount":10.01}`} {
+		if w := doRequest(t, r, http.MethodPost, refund, body, "If-Match", "*"); w.Code != http.StatusBadRequest {
+			t.Errorf("refund %s = %d, want 400", body, w.Code)
+		}
+	}
+
+	w := doRequest(t, r, http.MethodPost, refund, `{"amount":3.3}`, "If-Match", "*")
+	if got := decodeJSON[Payment](t, w); w.Code != http.StatusOK || got.Status != "refunded" || got.RefundAmount != 3.3 || got.RefundedAt == nil {
+		t.Fatalf("partial refund = %d %s, want refunded with 3.30 refunded", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodPost, refund, `{"amount":7}`, "If-Match", "*"); w.Code != http.StatusBadRequest {
+		t.Fatalf("refund over the remaining 6.70 = %d, want 400", w.Code)
+	}
+	w = doRequest(t, r, http.MethodPost, refund, "", "If-Match", "*")
+	if got := decodeJSON[Payment](t, w); w.Code != http.StatusOK || got.RefundAmount != 10 {
+		t.Fatalf("refund of the rest = %d %s, want 10 refunded in total", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodPost, refund, "", "If-Match", "*"); w.Code != http.StatusConflict {
+		t.Fatalf("refund of a fully refunded payment = %d, want 409", w.Code)
+	}
+}
+
+func TestRefundOrderPayments(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	admin := useAdminToken(t)
+	orderID := uuid.NewString()
+
+	first := mustProcessPayment(t, r, mustCreatePayment(t, r, orderID, 10, "pix").ID)
+	second := mustProcessPayment(t, r, mustCreatePayment(t, r, orderID, 20, "pix").ID)
+	pending := mustCreatePayment(t, r, orderID, 5, "pix")
+	other := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+second.ID+"/refund", `{"amount":5}`, "If-Match", "*"); w.Code != http.StatusOK {
+		t.Fatalf("partial refund = %d %s, want 200", w.Code, w.Body.String())
+	}
+
+	type orderRefunds struct {
+		Refunds []orderRefund `json:"refunds"`
+	}
+	target := "/orders/" + orderID + "/refund"
+	if w := doRequest(t, r, http.MethodPost, target, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("order refund without the admin token = %d, want 401", w.Code)
+	}
+	w := doRequest(t, r, http.MethodPost, target, "", admin...)
+	refunded := map[string]Amount{}
+	for _, refund := range decodeJSON[orderRefunds](t, w).Refunds {
+		refunded[refund.PaymentID] = refund.Refunded
+	}
+	if w.Code != http.StatusOK || len(refunded) != 2 || refunded[first.ID] != 10 || refunded[second.ID] != 15 {
+		t.Fatalf("order refund = %d %s, want the first refunded 10 and the rest of the second 15", w.Code, w.Body.String())
+	}
+
+	statuses := map[string]string{first.ID: "refunded", second.ID: "refunded", pending.ID: "pending", other.ID: "completed"}
+	for id, want := range statuses {
+		if got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+id, "")); got.Status != want {
+			t.Errorf("payment %s is %s, want %s", id, got.Status, want)
+		}
+	}
+
+	w = doRequest(t, r, http.MethodPost, target, "", admin...)
+	if w.Code != http.StatusOK || len(decodeJSON[orderRefunds](t, w).Refunds) != 0 {
+		t.Fatalf("repeated order refund = %d %s, want nothing refunded twice", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodPost, "/orders/nope/refund", "", admin...); w.Code != http.StatusBadRequest {
+		t.Fatalf("refund for a malformed order = %d, want 400", w.Code)
+	}
+}
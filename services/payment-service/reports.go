@@ -0,0 +1,218 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxRecentMinutes = 24 * 60
+
+// listRecentPayments returns payments processed within the last N minutes,
+// most recently processed first.
+func listRecentPayments(c *gin.Context) {
+	minutes, err := strconv.Atoi(c.DefaultQuery("minutes", "5"))
+	if err != nil || minutes <= 0 || minutes > maxRecentMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "minutes must be an integer between 1 and 1440"})
+		return
+	}
+	cutoff := clock().Add(-time.Duration(minutes) * time.Minute)
+
+	// Copied under the lock; settlement may be updating the originals
+	recent := make([]Payment, 0)
+	paymentsMutex.RLock()
+	for _, payment := range payments.List() {
+		if payment.ProcessedAt != nil && !payment.ProcessedAt.Before(cutoff) {
+			recent = append(recent, *payment)
+		}
+	}
+	paymentsMutex.RUnlock()
+
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].ProcessedAt.After(*recent[j].ProcessedAt)
+	})
+	c.JSON(http.StatusOK, recent)
+}
+
+// orderPayments is one entry of GET /payments/orders?counts=true.
+type orderPayments struct {
+	OrderID  string `json:"order_id"`
+	Payments int    `json:"payments"`
+}
+
+// listPaymentOrders returns the distinct order IDs that have payments,
+// sorted, optionally with the number of payments for each.
+func listPaymentOrders(c *gin.Context) {
+	paymentsMutex.RLock()
+	counts := payments.OrderCounts()
+	paymentsMutex.RUnlock()
+
+	orderIDs := make([]string, 0, len(counts))
+	for orderID := range counts {
+		orderIDs = append(orderIDs, orderID)
+	}
+	sort.Strings(orderIDs)
+
+	if c.Query("counts") != "true" {
+		c.JSON(http.StatusOK, orderIDs)
+		return
+	}
+	orders := make([]orderPayments, 0, len(orderIDs))
+	for _, orderID := range orderIDs {
+		orders = append(orders, orderPayments{OrderID: orderID, Payments: counts[orderID]})
+	}
+	c.JSON(http.StatusOK, orders)
+}
+
+// methodOutcomes counts the processing outcomes of one payment method.
+type methodOutcomes struct {
+	Completed    int     `json:"completed"`
+	Failed       int     `json:"failed"`
+	Refunded     int     `json:"refunded"`
+	SuccessRatio float64 `json:"success_ratio"`
+}
+
+// getOutcomesByMethod reports success/failure rates per payment method.
+// Refunded payments were charged successfully, so they count as successes;
+// payments that were never processed are left out.
+func getOutcomesByMethod(c *gin.Context) {
+	outcomes := make(map[string]*methodOutcomes)
+	paymentsMutex.RLock()
+	for _, payment := range payments.List() {
+		if payment.Status != "completed" && payment.Status != "failed" && payment.Status != "refunded" {
+			continue
+		}
+		entry, exists := outcomes[payment.Method]
+		if !exists {
+			entry = &methodOutcomes{}
+			outcomes[payment.Method] = entry
+		}
+		switch payment.Status {
+		case "completed":
+			entry.Completed++
+		case "failed":
+			entry.Failed++
+		case "refunded":
+			entry.Refunded++
+		}
+	}
+	paymentsMutex.RUnlock()
+
+	for _, entry := range outcomes {
+		processed := entry.Completed + entry.Failed + entry.Refunded
+		entry.SuccessRatio = float64(entry.Completed+entry.Refunded) / float64(processed)
+	}
+	c.JSON(http.StatusOK, outcomes)
+}
+
+const maxRateBuckets = 1440
+
+type rateBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// getCreationRate returns payment-creation counts per bucket over the
+// trailing window, oldest bucket first.
+func getCreationRate(c *gin.Context) {
+	window, werr := time.ParseDuration(c.DefaultQuery("window", "5m"))
+	bucket, berr := time.ParseDuration(c.DefaultQuery("bucket", "1m"))
+	if werr != nil || berr != nil || window <= 0 || bucket <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window and bucket must be positive durations such as 5m"})
+		return
+	}
+	if bucket > window || window%bucket != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be a whole multiple of bucket"})
+		return
+	}
+	count := int(window / bucket)
+	if count > maxRateBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window/bucket yields too many buckets (max 1440)"})
+		return
+	}
+
+	end := clock()
+	start := end.Add(-window)
+	buckets := make([]rateBucket, count)
+	for i := range buckets {
+		buckets[i].Start = start.Add(time.Duration(i) * bucket)
+	}
+
+	paymentsMutex.RLock()
+	for _, payment := range payments.List() {
+		if payment.CreatedAt.Before(start) || payment.CreatedAt.After(end) {
+			continue
+		}
+		index := int(payment.CreatedAt.Sub(start) / bucket)
+		if index == count {
+			index-- // created exactly at the end of the window
+		}
+		buckets[index].Count++
+	}
+	paymentsMutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"window":  window.String(),
+		"bucket":  bucket.String(),
+		"buckets": buckets,
+	})
+}
+
+// paymentStats is the body of GET /payments/stats. Amounts are only added
+// up within a currency.
+type paymentStats struct {
+	Count      int                      `json:"count"`
+	ByCurrency map[string]currencyStats `json:"by_currency"`
+	ByStatus   map[string]int           `json:"by_status"`
+	ByMethod   map[string]int           `json:"by_method"`
+}
+
+// currencyStats sums the payments in one currency.
+type currencyStats struct {
+	Count         int     `json:"count"`
+	TotalAmount   float64 `json:"total_amount"`
+	AverageAmount float64 `json:"average_amount"`
+}
+
+// getPaymentStats aggregates payments by currency, status and method,
+// optionally only those created at or after ?since (RFC3339).
+func getPaymentStats(c *gin.Context) {
+	var since time.Time
+	if raw := c.Query("since"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC3339 timestamp such as 2024-01-02T15:04:05Z"})
+			return
+		}
+		since = parsed
+	}
+
+	stats := paymentStats{
+		ByCurrency: make(map[string]currencyStats),
+		ByStatus:   make(map[string]int),
+		ByMethod:   make(map[string]int),
+	}
+	paymentsMutex.RLock()
+	for _, payment := range payments.List() {
+		if payment.CreatedAt.Before(since) {
+			continue
+		}
+		stats.Count++
+		totals := stats.ByCurrency[payment.Currency]
+		totals.Count++
+		totals.TotalAmount += float64(payment.Amount)
+		stats.ByCurrency[payment.Currency] = totals
+		stats.ByStatus[payment.Status]++
+		stats.ByMethod[payment.Method]++
+	}
+	paymentsMutex.RUnlock()
+
+	for currency, totals := range stats.ByCurrency {
+		totals.AverageAmount = totals.TotalAmount / float64(totals.Count)
+		stats.ByCurrency[currency] = totals
+	}
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GET /payments/recent answers with copies, so encoding them doesn't race
+// with refunds of the same payments (run with -race).
+func TestRecentPaymentsWhileRefunding(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+
+	ids := make([]string, 10)
+	for i := range ids {
+		ids[i] = mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID
+		mustProcessPayment(t, r, ids[i])
+	}
+
+	var refunds sync.WaitGroup
+	refunds.Add(1)
+	go func() {
+		defer refunds.Done()
+		for _, id := range ids {
+			doRequest(t, r, http.MethodPost, "/payments/"+id+"/refund", "", "If-Match", "*")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if w := doRequest(t, r, http.MethodGet, "/payments/recent", ""); w.Code != http.StatusOK {
+			t.Errorf("GET /payments/recent = %d %s", w.Code, w.Body.String())
+		}
+	}
+	refunds.Wait()
+
+	recent := decodeJSON[[]Payment](t, doRequest(t, r, http.MethodGet, "/payments/recent", ""))
+	for _, payment := range recent {
+		if payment.Status != "refunded" {
+			t.Fatalf("recent payment %s is %s, want refunded", payment.ID, payment.Status)
+		}
+	}
+}
+
+func TestRecentPaymentsWindow(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+
+	old := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	advance(10 * time.Minute)
+	older := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	advance(time.Minute)
+	newest := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix") // pending
+
+	recent := decodeJSON[[]Payment](t, doRequest(t, r, http.MethodGet, "/payments/recent", ""))
+	if len(recent) != 2 || recent[0].ID != newest.ID || recent[1].ID != older.ID {
+		t.Fatalf("recent = %v, want the last two processed, newest first", recent)
+	}
+	recent = decodeJSON[[]Payment](t, doRequest(t, r, http.MethodGet, "/payments/recent?minutes=15", ""))
+	if len(recent) != 3 || recent[2].ID != old.ID {
+		t.Fatalf("recent 15 minutes = %v, want all three processed", recent)
+	}
+	for _, minutes := range []string{"0", "1441", "soon"} {
+		if w := doRequest(t, r, http.MethodGet, "/payments/recent?minutes="+minutes, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /payments/recent?minutes=%s = %d, want 400", minutes, w.Code)
+		}
+	}
+}
+
+func TestOutcomesByMethod(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+
+	mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	refunded := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+refunded.ID+"/refund", "", "If-Match", "*"); w.Code != http.StatusOK {
+		t.Fatalf("refund = %d %s, want 200", w.Code, w.Body.String())
+	}
+	doRequest(t, r, http.MethodPost, "/payments/"+mustCreatePayment(t, r, uuid.NewString(), 5000, "pix").ID+"/process", "", "If-Match", "*")
+	mustCreatePayment(t, r, uuid.NewString(), 10, "boleto") // never processed
+
+	outcomes := decodeJSON[map[string]methodOutcomes](t, doRequest(t, r, http.MethodGet, "/payments/outcomes-by-method", ""))
+	want := methodOutcomes{Completed: 1, Failed: 1, Refunded: 1, SuccessRatio: 2.0 / 3}
+	if len(outcomes) != 1 || outcomes["pix"] != want {
+		t.Fatalf("outcomes = %+v, want only pix with %+v", outcomes, want)
+	}
+}
+
+func TestCreationRate(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	advance(90 * time.Second)
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	advance(time.Minute)
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+
+	rate := decodeJSON[struct {
+		Buckets []rateBucket `json:"buckets"`
+	}](t, doRequest(t, r, http.MethodGet, "/payments/creation-rate", ""))
+	want := []int{0, 0, 1, 0, 3}
+	if len(rate.Buckets) != len(want) {
+		t.Fatalf("buckets = %+v, want %d", rate.Buckets, len(want))
+	}
+	for i, bucket := range rate.Buckets {
+		if bucket.Count != want[i] {
+			t.Fatalf("bucket counts = %+v, want %v", rate.Buckets, want)
+		}
+	}
+
+	for _, query := range []string{"window=1m&bucket=5m", "window=5m&bucket=2m", "window=48h&bucket=1m", "window=soon"} {
+		if w := doRequest(t, r, http.MethodGet, "/payments/creation-rate?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /payments/creation-rate?%s = %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestPaymentOrders(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	first, second := "11111111-1111-4111-8111-111111111111", "22222222-2222-4222-8222-222222222222"
+	mustCreatePayment(t, r, second, 10, "pix")
+	mustCreatePayment(t, r, first, 10, "pix")
+	mustCreatePayment(t, r, second, 10, "pix")
+
+	ids := decodeJSON[[]string](t, doRequest(t, r, http.MethodGet, "/payments/orders", ""))
+	if len(ids) != 2 || ids[0] != first || ids[1] != second {
+		t.Fatalf("order IDs = %v, want both orders once, sorted", ids)
+	}
+	counts := decodeJSON[[]orderPayments](t, doRequest(t, r, http.MethodGet, "/payments/orders?counts=true", ""))
+	want := []orderPayments{{OrderID: first, Payments: 1}, {OrderID: second, Payments: 2}}
+	if len(counts) != 2 || counts[0] != want[0] || counts[1] != want[1] {
+		t.Fatalf("order counts = %+v, want %+v", counts, want)
+	}
+}
+
+// Amounts in different currencies are never added together.
+func TestPaymentStatsByCurrency(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	mustCreatePayment(t, r, uuid.NewString(), 30, "pix")
+	body := `{"order_id":"` + uuid.NewString() + `","amount":1500,"method":"pix","currency":"JPY"}`
+	if w := doRequest(t, r, http.MethodPost, "/payments", body); w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s, want 201", w.Code, w.Body.String())
+	}
+
+	stats := decodeJSON[paymentStats](t, doRequest(t, r, http.MethodGet, "/payments/stats", ""))
+	want := map[string]currencyStats{
+		"USD": {Count: 2, TotalAmount: 40, AverageAmount: 20},
+		"JPY": {Count: 1, TotalAmount: 1500, AverageAmount: 1500},
+	}
+	if stats.Count != 3 || len(stats.ByCurrency) != len(want) {
+		t.Fatalf("stats = %+v, want 3 payments in USD and JPY", stats)
+	}
+	for currency, totals := range want {
+		if stats.ByCurrency[currency] != totals {
+			t.Errorf("%s stats = %+v, want %+v", currency, stats.ByCurrency[currency], totals)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// Order validation attempts, the first included (VALIDATION_MAX_ATTEMPTS)
+	validationMaxAttempts = 3
+	// Backoff before the first retry, doubling per attempt (VALIDATION_RETRY_BASE_DELAY)
+	validationRetryBaseDelay = 100 * time.Millisecond
+	// Ceiling on a single backoff before jitter (VALIDATION_RETRY_MAX_DELAY)
+	validationRetryMaxDelay = 2 * time.Second
+	// Retries all order validations together may make in a burst (VALIDATION_RETRY_BUDGET, 0 disables the budget)
+	validationRetryBudget = 20
+	// Retry tokens regained per second (VALIDATION_RETRY_BUDGET_REFILL)
+	validationRetryBudgetRefill = 2.0
+
+	retryBudgetMutex  = sync.Mutex{}
+	retryBudgetTokens float64
+	retryBudgetLast   time.Time
+)
+
+// takeRetryToken spends one token from the retry budget shared by every
+// order validation. When the order-service keeps failing the budget runs
+// dry and validations stop retrying, so a wave of payments can't multiply
+// the load on it by validationMaxAttempts.
+func takeRetryToken() bool {
+	if validationRetryBudget <= 0 {
+		return true
+	}
+	retryBudgetMutex.Lock()
+	defer retryBudgetMutex.Unlock()
+	refillRetryBudget(clock())
+	if retryBudgetTokens < 1 {
+		return false
+	}
+	retryBudgetTokens--
+	return true
+}
+
+// refillRetryBudget tops the budget up for the time elapsed since it was
+// last used; it starts out full. Caller must hold retryBudgetMutex.
+func refillRetryBudget(now time.Time) {
+	capacity := float64(validationRetryBudget)
+	if retryBudgetLast.IsZero() {
+		retryBudgetTokens = capacity
+	} else if elapsed := now.Sub(retryBudgetLast).Seconds(); elapsed > 0 {
+		retryBudgetTokens = math.Min(capacity, retryBudgetTokens+elapsed*validationRetryBudgetRefill)
+	}
+	retryBudgetLast = now
+}
+
+// retryBudgetRemaining reports the tokens left in the retry budget, or
+// +Inf when the budget is disabled.
+func retryBudgetRemaining() float64 {
+	if validationRetryBudget <= 0 {
+		return math.Inf(1)
+	}
+	retryBudgetMutex.Lock()
+	defer retryBudgetMutex.Unlock()
+	refillRetryBudget(clock())
+	return retryBudgetTokens
+}
+
+// retryDelay picks the backoff after the given zero-based attempt: a
+// uniformly random duration up to base doubled per attempt and capped at
+// validationRetryMaxDelay. The full jitter spreads out clients that failed
+// together so they don't retry in lockstep.
+func retryDelay(attempt int, base time.Duration) time.Duration {
+	ceiling := base
+	for i := 0; i < attempt && ceiling < validationRetryMaxDelay; i++ {
+		ceiling *= 2
+	}
+	ceiling = min(ceiling, validationRetryMaxDelay)
+	if ceiling <= 0 {
+		return 0
+	}
+	// The top-level math/rand source is randomly seeded and safe for
+	// concurrent use
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
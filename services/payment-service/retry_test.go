@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Each retried attempt closes the previous response, so every attempt
+// reuses one keep-alive connection instead of opening another.
+func TestValidationRetriesReuseTheConnection(t *testing.T) {
+	r := newTestRouter(t)
+	setVar(t, &validationMaxAttempts, 3)
+	setVar(t, &validationRetryBaseDelay, time.Millisecond)
+	var calls atomic.Int32
+	orders := newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, `{"error":"warming up"}`, http.StatusServiceUnavailable)
+			return
+		}
+		ordersFound(w, req)
+	})
+
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	if calls.Load() != 3 {
+		t.Fatalf("order-service called %d times, want 3", calls.Load())
+	}
+	if opened := orders.opened.Load(); opened != 1 {
+		t.Fatalf("validation opened %d connections, want 1", opened)
+	}
+}
+
+// One deadline bounds the whole validation, however many attempts are left.
+func TestValidationDeadlineSpansRetries(t *testing.T) {
+	r := newTestRouter(t)
+	setVar(t, &validationMaxAttempts, 50)
+	setVar(t, &validationRetryBaseDelay, 5*time.Millisecond)
+	setVar(t, &validationTotalTimeout, 100*time.Millisecond)
+	setVar(t, &orderBreaker.threshold, 1000)
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		http.Error(w, `{"error":"overloaded"}`, http.StatusServiceUnavailable)
+	})
+
+	start := time.Now()
+	w := doRequest(t, r, http.MethodPost, "/payments?debug=true", createPaymentBody(uuid.NewString(), 10, "pix"))
+	elapsed := time.Since(start)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("create = %d %s, want 503", w.Code, w.Body.String())
+	}
+	trace := decodeJSON[struct {
+		Diagnostic validationTrace `json:"diagnostic"`
+	}](t, w).Diagnostic
+	if trace.LastError != errValidationDeadline.Error() || trace.Attempts >= validationMaxAttempts {
+		t.Fatalf("trace = %+v, want the deadline to stop the retries", trace)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("validation took %v, want it bounded by the 100ms deadline", elapsed)
+	}
+}
+
+// An order-service that rate limits every attempt leaves the order
+// unverified: creation fails with 503 and nothing is cached as valid.
+func TestPersistentRateLimitIsNotCachedAsValid(t *testing.T) {
+	r := newTestRouter(t)
+	setVar(t, &validationMaxAttempts, 3)
+	setVar(t, &validationRetryBaseDelay, time.Millisecond)
+	var calls atomic.Int32
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		http.Error(w, `{"error":"slow down"}`, http.StatusTooManyRequests)
+	})
+	orderID := uuid.NewString()
+
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(orderID, 10, "pix"))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("create while rate limited = %d %s, want 503", w.Code, w.Body.String())
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("order-service called %d times, want every attempt used", calls.Load())
+	}
+	if _, cached := cachedOrder(orderID); cached {
+		t.Fatal("rate-limited validation was cached")
+	}
+}
+
+// Once failing validations have spent the shared retry budget, later ones
+// make a single attempt until it refills.
+func TestRetryBudgetStopsRetryStorms(t *testing.T) {
+	r := newTestRouter(t)
+	advance := useFakeClock(t)
+	setVar(t, &validationMaxAttempts, 3)
+	setVar(t, &validationRetryBaseDelay, time.Millisecond)
+	setVar(t, &validationRetryBudget, 2)
+	setVar(t, &validationRetryBudgetRefill, 1.0)
+	setVar(t, &orderBreaker.threshold, 1000)
+	var calls atomic.Int32
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		http.Error(w, `{"error":"down"}`, http.StatusServiceUnavailable)
+	})
+	create := func() int32 {
+		calls.Store(0)
+		if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix")); w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("create while the order-service fails = %d %s, want 503", w.Code, w.Body.String())
+		}
+		return calls.Load()
+	}
+
+	if got := create(); got != 3 {
+		t.Fatalf("attempts with a full budget = %d, want 3", got)
+	}
+	if got := testutil.ToFloat64(orderValidationRetryBudget); got != 0 {
+		t.Fatalf("retry budget gauge = %v, want 0", got)
+	}
+	if got := create(); got != 1 {
+		t.Fatalf("attempts with the budget spent = %d, want 1", got)
+	}
+	advance(time.Second)
+	if got := testutil.ToFloat64(orderValidationRetryBudget); got != 1 {
+		t.Fatalf("retry budget gauge after a second = %v, want 1", got)
+	}
+	if got := create(); got != 2 {
+		t.Fatalf("attempts after a second's refill = %d, want 2", got)
+	}
+}
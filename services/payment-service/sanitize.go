@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Sanitization policies for free-text request fields.
+const (
+	// sanitizeEscape HTML-escapes the value (the historical behaviour)
+	sanitizeEscape = "escape"
+	// sanitizeStrip removes markup and characters that could form markup
+	sanitizeStrip = "strip"
+	// sanitizeReject refuses values containing markup characters
+	sanitizeReject = "reject"
+)
+
+var (
+	sanitizationPolicy = sanitizeEscape
+	markupTagPattern   = regexp.MustCompile(`<[^>]*>`)
+)
+
+const markupChars = `<>"'&`
+
+// sanitizeField applies the configured policy to one free-text field.
+func sanitizeField(name, value string) (string, error) {
+	switch sanitizationPolicy {
+	case sanitizeStrip:
+		value = markupTagPattern.ReplaceAllString(value, "")
+		value = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) || strings.ContainsRune(markupChars, r) {
+				return -1
+			}
+			return r
+		}, value)
+		return strings.TrimSpace(value), nil
+	case sanitizeReject:
+		if strings.ContainsAny(value, markupChars) || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return "", fmt.Errorf("%s contains disallowed characters", name)
+		}
+		return value, nil
+	default:
+		return html.EscapeString(value), nil
+	}
+}
+
+// sanitizeCreateRequest applies the policy uniformly to every free-text
+// field of a create request.
+func sanitizeCreateRequest(req *CreatePaymentRequest) error {
+	var err error
+	if req.OrderID, err = sanitizeField("order_id", req.OrderID); err != nil {
+		return err
+	}
+	if req.Method, err = sanitizeField("method", req.Method); err != nil {
+		return err
+	}
+	if len(req.Metadata) > 0 {
+		sanitized := make(map[string]string, len(req.Metadata))
+		for key, value := range req.Metadata {
+			if key, err = sanitizeField("metadata key", key); err != nil {
+				return err
+			}
+			if sanitized[key], err = sanitizeField("metadata value", value); err != nil {
+				return err
+			}
+		}
+		req.Metadata = sanitized
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSanitizeField(t *testing.T) {
+	tests := []struct {
+		policy  string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{sanitizeEscape, `<b>"fast"</b>`, "&lt;b&gt;&#34;fast&#34;&lt;/b&gt;", false},
+		{sanitizeStrip, " <b>fast</b> & \"cheap\"\x07 ", "fast  cheap", false},
+		{sanitizeReject, "plain text", "plain text", false},
+		{sanitizeReject, "<script>", "", true},
+		{sanitizeReject, "bell\x07", "", true},
+	}
+	for _, tt := range tests {
+		setVar(t, &sanitizationPolicy, tt.policy)
+		got, err := sanitizeField("reason", tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s policy: sanitizeField(%q) = %q, %v; want %q, error %v", tt.policy, tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSanitizationPolicyAppliesToCancelReason(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	body := `{"reason":"<i>customer</i> request"}`
+
+	setVar(t, &sanitizationPolicy, sanitizeReject)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/cancel", body); w.Code != http.StatusBadRequest {
+		t.Fatalf("cancel with markup under the reject policy = %d, want 400", w.Code)
+	}
+
+	sanitizationPolicy = sanitizeStrip
+	w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/cancel", body)
+	if got := decodeJSON[Payment](t, w).CancelReason; w.Code != http.StatusOK || got != "customer request" {
+		t.Fatalf("cancel under the strip policy = %d, reason %q; want 200 and %q", w.Code, got, "customer request")
+	}
+}
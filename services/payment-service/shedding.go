@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Creations are shed once this many payments are stored (0 disables)
+	maxStoredPayments = 0
+	// Creations are shed once the heap exceeds this many MB (0 disables)
+	maxHeapMB = 0
+	// Heap usage is sampled at most once per second; ReadMemStats stops the world
+	heapSampleMutex = sync.Mutex{}
+	lastHeapSample  time.Time
+	lastHeapMB      uint64
+)
+
+func heapInUseMB() uint64 {
+	heapSampleMutex.Lock()
+	defer heapSampleMutex.Unlock()
+	if time.Since(lastHeapSample) > time.Second {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		lastHeapMB = stats.HeapInuse >> 20
+		lastHeapSample = time.Now()
+	}
+	return lastHeapMB
+}
+
+// shouldShedLoad reports whether new payments should be refused to protect
+// the process from unbounded growth.
+func shouldShedLoad() bool {
+	if maxStoredPayments > 0 {
+		paymentsMutex.RLock()
+		stored := payments.Len()
+		paymentsMutex.RUnlock()
+		if stored >= maxStoredPayments {
+			return true
+		}
+	}
+	return maxHeapMB > 0 && heapInUseMB() >= uint64(maxHeapMB)
+}
+
+// loadSheddingMiddleware refuses requests with 503 while over the limits.
+// It is only attached to creation routes so reads and processing continue.
+func loadSheddingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if shouldShedLoad() {
+			c.Header("Retry-After", "5")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Payment service is over capacity, retry later"})
+			return
+		}
+		c.Next()
+	}
+}
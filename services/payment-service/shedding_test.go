@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestStoreLen(t *testing.T) {
+	file, err := openFileStore(filepath.Join(t.TempDir(), "payments.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]PaymentStore{"memory": newMemoryStore(), "file": file} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				if err := store.Save(&Payment{ID: uuid.NewString(), OrderID: "order"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := store.Len(); got != 3 {
+				t.Fatalf("Len() = %d, want 3", got)
+			}
+		})
+	}
+}
+
+func TestCreationsShedAtStoredPaymentLimit(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &maxStoredPayments, 1)
+
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("create over the limit = %d %s, want 503 with Retry-After", w.Code, w.Body.String())
+	}
+}
+
+// Over the heap limit only creations are shed; reads and processing go on.
+func TestCreationsShedOverHeapLimit(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	setVar(t, &maxHeapMB, 1)
+
+	if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix")); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("create over the heap limit = %d, want 503", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodGet, "/payments/"+payment.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("GET over the heap limit = %d, want 200", w.Code)
+	}
+	mustProcessPayment(t, r, payment.ID)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// PaymentStore holds the service's payments. Implementations do no locking
+// of their own: callers hold paymentsMutex, the read lock for Get, List and Len
+// and the write lock for Save and Update, just as they did for the plain map.
+type PaymentStore interface {
+	// Save adds a new payment, failing if its ID is already taken.
+	Save(payment *Payment) error
+	Get(id string) (*Payment, bool)
+	// List returns every payment, in no particular order.
+	List() []*Payment
+	// Len reports how many payments are stored.
+	Len() int
+	// ListByOrder returns the payments of one order, in the order saved.
+	ListByOrder(orderID string) []*Payment
+	// OrderCounts reports how many payments each order has.
+	OrderCounts() map[string]int
+	// Update records changes made to a stored payment.
+	Update(payment *Payment) error
+	Delete(id string) error
+	// Clear removes every payment and reports how many there were.
+	Clear() (int, error)
+}
+
+var (
+	errPaymentNotStored   = errors.New("payment is not in the store")
+	errDuplicatePaymentID = errors.New("payment ID is already stored")
+)
+
+var (
+	// Which PaymentStore backs the service: "memory" or "file" (PAYMENT_STORE)
+	paymentStoreKind = "memory"
+	// Where the file store keeps payments (PAYMENT_STORE_PATH)
+	paymentStorePath = "payments.json"
+	// What loading does with a payment ID seen twice (RESTORE_CONFLICT_POLICY):
+	// "reject" the file, "skip" later copies, or "overwrite" with them
+	restoreConflictPolicy = "reject"
+)
+
+// openPaymentStore replaces the default in-memory store with the one
+// selected by configuration. It is called once at startup.
+func openPaymentStore() error {
+	if paymentStoreKind != "file" {
+		return nil
+	}
+	store, err := openFileStore(paymentStorePath)
+	if err != nil {
+		return err
+	}
+	payments = store
+	return nil
+}
+
+// persistPayment records in-place changes to a payment and bumps its
+// Version. The in-memory copy is already updated, so a failure is only
+// reported. The caller must hold
+// paymentsMutex for writing.
+func persistPayment(payment *Payment) {
+	payment.Version++
+	if err := payments.Update(payment); err != nil {
+		logger.Error("failed to persist payment", "payment_id", payment.ID, "error", err.Error())
+	}
+}
+
+// memoryStore keeps payments in a map; they are lost on restart. byOrder
+// indexes payment IDs by order so one order's payments are found without
+// scanning every payment.
+type memoryStore struct {
+	payments map[string]*Payment
+	byOrder  map[string][]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		payments: make(map[string]*Payment),
+		byOrder:  make(map[string][]string),
+	}
+}
+
+// put stores a payment and indexes it, replacing any payment with its ID.
+func (s *memoryStore) put(payment *Payment) {
+	if existing, exists := s.payments[payment.ID]; exists {
+		if existing.OrderID == payment.OrderID {
+			s.payments[payment.ID] = payment
+			return
+		}
+		s.remove(payment.ID)
+	}
+	s.payments[payment.ID] = payment
+	s.byOrder[payment.OrderID] = append(s.byOrder[payment.OrderID], payment.ID)
+}
+
+// remove drops a payment and its index entry.
+func (s *memoryStore) remove(id string) {
+	payment, exists := s.payments[id]
+	if !exists {
+		return
+	}
+	delete(s.payments, id)
+	ids := s.byOrder[payment.OrderID]
+	for i, indexed := range ids {
+		if indexed == id {
+			ids = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(s.byOrder, payment.OrderID)
+	} else {
+		s.byOrder[payment.OrderID] = ids
+	}
+}
+
+func (s *memoryStore) Save(payment *Payment) error {
+	if _, exists := s.payments[payment.ID]; exists {
+		return errDuplicatePaymentID
+	}
+	s.put(payment)
+	return nil
+}
+
+func (s *memoryStore) Get(id string) (*Payment, bool) {
+	payment, exists := s.payments[id]
+	return payment, exists
+}
+
+func (s *memoryStore) List() []*Payment {
+	list := make([]*Payment, 0, len(s.payments))
+	for _, payment := range s.payments {
+		list = append(list, payment)
+	}
+	return list
+}
+
+func (s *memoryStore) Len() int {
+	return len(s.payments)
+}
+
+func (s *memoryStore) ListByOrder(orderID string) []*Payment {
+	ids := s.byOrder[orderID]
+	list := make([]*Payment, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, s.payments[id])
+	}
+	return list
+}
+
+func (s *memoryStore) OrderCounts() map[string]int {
+	counts := make(map[string]int, len(s.byOrder))
+	for orderID, ids := range s.byOrder {
+		counts[orderID] = len(ids)
+	}
+	return counts
+}
+
+func (s *memoryStore) Update(payment *Payment) error {
+	if _, exists := s.payments[payment.ID]; !exists {
+		return errPaymentNotStored
+	}
+	s.put(payment)
+	return nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	if _, exists := s.payments[id]; !exists {
+		return errPaymentNotStored
+	}
+	s.remove(id)
+	return nil
+}
+
+func (s *memoryStore) Clear() (int, error) {
+	removed := len(s.payments)
+	s.payments = make(map[string]*Payment)
+	s.byOrder = make(map[string][]string)
+	return removed, nil
+}
+
+// fileStore serves payments from memory and rewrites a JSON file after
+// every change, so they survive a restart. Unexported fields, such as an
+// order-dictated outcome, are not persisted.
+type fileStore struct {
+	*memoryStore
+	path string
+}
+
+// openFileStore loads the payments already saved at path, if any. Entries
+// without a valid ID fail the load; duplicate IDs are resolved by
+// restoreConflictPolicy.
+func openFileStore(path string) (*fileStore, error) {
+	store := &fileStore{memoryStore: newMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []*Payment
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	for i, payment := range saved {
+		if payment == nil || !isValidPaymentID(payment.ID) {
+			return nil, fmt.Errorf("reading %s: entry %d has no valid payment ID", path, i)
+		}
+		if _, duplicate := store.payments[payment.ID]; duplicate {
+			switch restoreConflictPolicy {
+			case "skip":
+				logger.Warn("skipping duplicate payment", "payment_id", payment.ID, "path", path)
+				continue
+			case "overwrite":
+				logger.Warn("overwriting duplicate payment", "payment_id", payment.ID, "path", path)
+			default:
+				return nil, fmt.Errorf("reading %s: duplicate payment ID %s", path, payment.ID)
+			}
+		}
+		// Processing that a restart interrupted never recorded an outcome
+		if payment.Status == "processing" {
+			payment.Status = "pending"
+		}
+		store.put(payment)
+	}
+	return store, nil
+}
+
+func (s *fileStore) Save(payment *Payment) error {
+	if err := s.memoryStore.Save(payment); err != nil {
+		return err
+	}
+	if err := s.flush(); err != nil {
+		s.remove(payment.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) Update(payment *Payment) error {
+	if err := s.memoryStore.Update(payment); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+func (s *fileStore) Delete(id string) error {
+	payment, exists := s.payments[id]
+	if !exists {
+		return errPaymentNotStored
+	}
+	s.remove(id)
+	if err := s.flush(); err != nil {
+		s.put(payment)
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) Clear() (int, error) {
+	saved, savedIndex := s.payments, s.byOrder
+	removed, _ := s.memoryStore.Clear()
+	if err := s.flush(); err != nil {
+		s.payments, s.byOrder = saved, savedIndex
+		return 0, err
+	}
+	return removed, nil
+}
+
+// flush writes every payment to a temporary file and renames it over the
+// store, so a crash mid-write never leaves a truncated file behind.
+func (s *fileStore) flush() error {
+	list := s.List()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
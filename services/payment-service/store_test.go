@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Payments written through the API are there again after a restart.
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	path := filepath.Join(t.TempDir(), "payments.json")
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments = store
+
+	processed := mustProcessPayment(t, r, mustCreatePayment(t, r, uuid.NewString(), 10, "pix").ID)
+	pending := mustCreatePayment(t, r, uuid.NewString(), 20, "boleto")
+
+	restarted, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments = restarted
+	for id, status := range map[string]string{processed.ID: "completed", pending.ID: "pending"} {
+		w := doRequest(t, r, http.MethodGet, "/payments/"+id, "")
+		if got := decodeJSON[Payment](t, w); w.Code != http.StatusOK || got.Status != status {
+			t.Errorf("payment %s after restart = %d %s, want it %s", id, w.Code, w.Body.String(), status)
+		}
+	}
+}
+
+// An ID issued again after a restart is refused rather than replacing the
+// restored payment.
+func TestFileStoreRefusesReissuedIDs(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar[IDGenerator](t, &idGenerator, &sequentialGenerator{})
+	path := filepath.Join(t.TempDir(), "payments.json")
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments = store
+	original := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+
+	restarted, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments = restarted
+	idGenerator = &sequentialGenerator{}
+	if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 20, "pix")); w.Code != http.StatusInternalServerError {
+		t.Fatalf("create reusing a restored ID = %d %s, want 500", w.Code, w.Body.String())
+	}
+	w := doRequest(t, r, http.MethodGet, "/payments/"+original.ID, "")
+	if got := decodeJSON[Payment](t, w); w.Code != http.StatusOK || got.OrderID != original.OrderID || got.Amount != 10 {
+		t.Fatalf("restored payment = %d %s, want it unchanged", w.Code, w.Body.String())
+	}
+}
+
+// writeStoreFile saves payments as the file store would, duplicates and all.
+func writeStoreFile(t *testing.T, saved ...Payment) string {
+	t.Helper()
+	data, err := json.Marshal(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "payments.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRestoreConflictPolicy(t *testing.T) {
+	id := uuid.NewString()
+	path := writeStoreFile(t,
+		Payment{ID: id, OrderID: "first", Status: "pending"},
+		Payment{ID: id, OrderID: "second", Status: "completed"},
+	)
+
+	tests := []struct {
+		policy  string
+		want    string
+		wantErr bool
+	}{
+		{"reject", "", true},
+		{"skip", "first", false},
+		{"overwrite", "second", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			setVar(t, &restoreConflictPolicy, tt.policy)
+			store, err := openFileStore(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("loading duplicate payment IDs succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			payment, _ := store.Get(id)
+			if store.Len() != 1 || payment.OrderID != tt.want {
+				t.Fatalf("restored %d payments, kept %+v; want only the %s copy", store.Len(), payment, tt.want)
+			}
+			if byOrder := store.ListByOrder(tt.want); len(byOrder) != 1 {
+				t.Fatalf("order index lists %d payments for %s, want 1", len(byOrder), tt.want)
+			}
+			if counts := store.OrderCounts(); len(counts) != 1 || counts[tt.want] != 1 {
+				t.Fatalf("order counts = %v, want one payment for %s", counts, tt.want)
+			}
+		})
+	}
+}
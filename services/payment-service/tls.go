@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+var (
+	tlsCertFile = ""
+	tlsKeyFile  = ""
+	// TLS 1.2 suites offered when TLS is enabled; TLS 1.3 suites are fixed by Go
+	tlsCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	}
+	tlsCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}
+)
+
+var curvesByName = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+func tlsEnabled() bool {
+	return tlsCertFile != "" && tlsKeyFile != ""
+}
+
+func buildTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CipherSuites:     tlsCipherSuites,
+		CurvePreferences: tlsCurves,
+	}
+}
+
+// parseCipherSuites resolves suite names such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Suites Go considers insecure are
+// refused so a misconfiguration cannot weaken the baseline. HTTP/2 requires
+// an ECDHE AES-128-GCM suite, and net/http refuses to start without one, so
+// a list lacking it is refused too.
+func parseCipherSuites(raw string) ([]uint16, error) {
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	var suites []uint16
+	http2Capable := false
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if insecure[name] {
+			return nil, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+		id, exists := secure[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		switch id {
+		case tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256:
+			http2Capable = true
+		}
+		suites = append(suites, id)
+	}
+	if !http2Capable {
+		return nil, fmt.Errorf("HTTP/2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 in the list")
+	}
+	return suites, nil
+}
+
+func parseCurves(raw string) ([]tls.CurveID, error) {
+	var curves []tls.CurveID
+	for _, name := range strings.Split(raw, ",") {
+		curve, exists := curvesByName[strings.ToUpper(strings.TrimSpace(name))]
+		if !exists {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		curves = append(curves, curve)
+	}
+	return curves, nil
+}
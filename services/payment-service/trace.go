@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The types below follow the OTLP/JSON trace encoding so an exported
+// timeline can be loaded into Jaeger or any OTLP-compatible viewer.
+
+type otlpTrace struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string        `json:"key"`
+	Value otlpAttrValue `json:"value"`
+}
+
+type otlpAttrValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+const otlpSpanKindInternal = 1
+
+// traceID and spanID derive stable IDs from the payment ID so repeated exports of
+// the same payment land in the same trace.
+func traceID(paymentID string) string {
+	sum := sha256.Sum256([]byte(paymentID))
+	return hex.EncodeToString(sum[:16])
+}
+
+func spanID(paymentID, name string) string {
+	sum := sha256.Sum256([]byte(paymentID + "/" + name))
+	return hex.EncodeToString(sum[:8])
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func stringAttr(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpAttrValue{StringValue: value}}
+}
+
+// buildPaymentTrace turns a payment's recorded timestamps into a root span
+// covering its whole life with one child span per lifecycle stage.
+func buildPaymentTrace(payment *Payment) otlpTrace {
+	tid := traceID(payment.ID)
+	rootID := spanID(payment.ID, "payment")
+	end := payment.CreatedAt
+
+	child := func(name string, start, finish time.Time, attrs ...otlpAttribute) otlpSpan {
+		if finish.After(end) {
+			end = finish
+		}
+		return otlpSpan{
+			TraceID:           tid,
+			SpanID:            spanID(payment.ID, name),
+			ParentSpanID:      rootID,
+			Name:              name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: unixNano(start),
+			EndTimeUnixNano:   unixNano(finish),
+			Attributes:        attrs,
+		}
+	}
+
+	spans := []otlpSpan{child("payment.created", payment.CreatedAt, payment.CreatedAt)}
+	if payment.ProcessedAt != nil {
+		spans = append(spans, child("payment.processing", payment.CreatedAt, *payment.ProcessedAt,
+			stringAttr("payment.status", payment.Status)))
+	}
+	if payment.CancelledAt != nil {
+		spans = append(spans, child("payment.cancelled", payment.CreatedAt, *payment.CancelledAt))
+	}
+	if payment.ExpiredAt != nil {
+		spans = append(spans, child("payment.expired", payment.CreatedAt, *payment.ExpiredAt))
+	}
+
+	root := otlpSpan{
+		TraceID:           tid,
+		SpanID:            rootID,
+		Name:              "payment",
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: unixNano(payment.CreatedAt),
+		EndTimeUnixNano:   unixNano(end),
+		Attributes: []otlpAttribute{
+			stringAttr("payment.id", payment.ID),
+			stringAttr("order.id", payment.OrderID),
+			stringAttr("payment.method", payment.Method),
+			stringAttr("payment.status", payment.Status),
+		},
+	}
+
+	return otlpTrace{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{stringAttr("service.name", "payment-service")}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "payment-service/timeline"},
+			Spans: append([]otlpSpan{root}, spans...),
+		}},
+	}}}
+}
+
+// getPaymentTrace exports a payment's lifecycle as an OTLP/JSON trace.
+func getPaymentTrace(c *gin.Context) {
+	paymentsMutex.RLock()
+	defer paymentsMutex.RUnlock()
+
+	payment, exists := payments.Get(c.Param("payment_id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	c.JSON(http.StatusOK, buildPaymentTrace(payment))
+}
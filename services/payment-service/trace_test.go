@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPaymentTraceExport(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	advance(2 * time.Second)
+	mustProcessPayment(t, r, payment.ID)
+
+	w := doRequest(t, r, http.MethodGet, "/payments/"+payment.ID+"/trace", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET trace = %d %s, want 200", w.Code, w.Body.String())
+	}
+	spans := decodeJSON[otlpTrace](t, w).ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 || spans[0].Name != "payment" || spans[1].Name != "payment.created" || spans[2].Name != "payment.processing" {
+		t.Fatalf("spans = %+v, want the root, created and processing spans", spans)
+	}
+	root := spans[0]
+	start, _ := strconv.ParseInt(root.StartTimeUnixNano, 10, 64)
+	end, _ := strconv.ParseInt(root.EndTimeUnixNano, 10, 64)
+	if time.Duration(end-start) != 2*time.Second {
+		t.Errorf("root span lasts %v, want 2s", time.Duration(end-start))
+	}
+	for _, span := range spans[1:] {
+		if span.TraceID != root.TraceID || span.ParentSpanID != root.SpanID {
+			t.Errorf("span %s = %+v, want it in the payment's trace under the root", span.Name, span)
+		}
+	}
+
+	if w := doRequest(t, r, http.MethodGet, "/payments/"+uuid.NewString()+"/trace", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET trace of an unknown payment = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// OTLP/HTTP collector URL spans are exported to, e.g.
+// http://otel-collector:4318 (OTEL_EXPORTER_OTLP_ENDPOINT). Empty disables
+// export; incoming trace context is still propagated to the order-service.
+var otlpEndpoint = ""
+
+// tracer is resolved through the global provider so spans started before
+// setupTracing are simply not recorded.
+var tracer = otel.Tracer("payment-service")
+
+// setupTracing installs the W3C trace-context propagator and, when an OTLP
+// endpoint is configured, a batching exporter. The returned function flushes
+// pending spans on shutdown.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if otlpEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter reads OTEL_EXPORTER_OTLP_* itself, appending /v1/traces
+	// to the endpoint as the OTLP spec prescribes
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "payment-service"))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware starts a server span per request, continuing the trace
+// named by an incoming traceparent header.
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+			oteltrace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+			))
+		defer span.End()
+		if paymentID := c.Param("payment_id"); paymentID != "" {
+			span.SetAttributes(attribute.String("payment.id", paymentID))
+		}
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// annotatePaymentSpan records a payment's identity and status on the
+// request's span.
+func annotatePaymentSpan(ctx context.Context, payment Payment) {
+	oteltrace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("payment.id", payment.ID),
+		attribute.String("order.id", payment.OrderID),
+		attribute.String("payment.status", payment.Status),
+	)
+}
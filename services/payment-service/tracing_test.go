@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans sends the service's spans to an in-memory recorder for the
+// rest of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	setVar(t, &tracer, provider.Tracer("payment-service"))
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+	return recorder
+}
+
+// spanAttribute returns the value of a span attribute, or "" when unset.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestPaymentCreationIsTraced(t *testing.T) {
+	r := newTestRouter(t)
+	spans := recordSpans(t)
+	traceparents := make(chan string, 1)
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		traceparents <- req.Header.Get("Traceparent")
+		ordersFound(w, req)
+	})
+	incoming := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix"), "Traceparent", incoming)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s, want 201", w.Code, w.Body.String())
+	}
+	payment := decodeJSON[Payment](t, w)
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("recorded %d spans, want the request and the order-service call", len(ended))
+	}
+	client, server := ended[0], ended[1]
+	if server.SpanKind() != oteltrace.SpanKindServer || client.SpanKind() != oteltrace.SpanKindClient {
+		t.Fatalf("span kinds = %v, %v; want a client span ended inside the server span", client.SpanKind(), server.SpanKind())
+	}
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span does not continue the incoming trace: parent %v", server.Parent())
+	}
+	if client.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("client span parent = %v, want the server span", client.Parent().SpanID())
+	}
+	for key, want := range map[attribute.Key]string{"payment.id": payment.ID, "order.id": payment.OrderID, "payment.status": "pending"} {
+		if got := spanAttribute(server, key); got != want {
+			t.Errorf("server span %s = %q, want %q", key, got, want)
+		}
+	}
+
+	want := "00-" + client.SpanContext().TraceID().String() + "-" + client.SpanContext().SpanID().String() + "-01"
+	if got := <-traceparents; got != want {
+		t.Errorf("traceparent sent to the order-service = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const ttfbSampleSize = 100
+
+var (
+	// Ring buffer of the most recent order-service TTFB samples
+	ttfbSamples     = make([]time.Duration, 0, ttfbSampleSize)
+	ttfbNext        = 0
+	ttfbSampleMutex = sync.Mutex{}
+)
+
+func recordOrderServiceTTFB(ttfb time.Duration) {
+	orderServiceTTFB.Observe(ttfb.Seconds())
+
+	ttfbSampleMutex.Lock()
+	defer ttfbSampleMutex.Unlock()
+	if len(ttfbSamples) < ttfbSampleSize {
+		ttfbSamples = append(ttfbSamples, ttfb)
+		return
+	}
+	ttfbSamples[ttfbNext] = ttfb
+	ttfbNext = (ttfbNext + 1) % ttfbSampleSize
+}
+
+// getOrderService issues a GET to the order-service, recording the time to
+// the first response byte so dependency latency can be told apart from our
+// own processing.
+func getOrderService(ctx context.Context, targetURL string) (*http.Response, error) {
+	ctx, span := tracer.Start(ctx, "GET order-service",
+		oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithAttributes(
+			attribute.String("http.request.method", http.MethodGet),
+			attribute.String("url.full", targetURL),
+		))
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if id := correlationID(ctx); id != "" {
+		req.Header.Set(correlationIDHeader, id)
+	}
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			recordOrderServiceTTFB(time.Since(start))
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// getDependencyTTFB summarises the recent order-service TTFB samples.
+func getDependencyTTFB(c *gin.Context) {
+	ttfbSampleMutex.Lock()
+	samples := append([]time.Duration(nil), ttfbSamples...)
+	ttfbSampleMutex.Unlock()
+
+	summary := gin.H{"dependency": "order-service", "samples": len(samples)}
+	if len(samples) > 0 {
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		var total time.Duration
+		for _, sample := range samples {
+			total += sample
+		}
+		summary["avg_ms"] = float64(total.Microseconds()) / float64(len(samples)) / 1000
+		summary["p50_ms"] = float64(samples[len(samples)/2].Microseconds()) / 1000
+		summary["p95_ms"] = float64(samples[len(samples)*95/100].Microseconds()) / 1000
+		summary["max_ms"] = float64(samples[len(samples)-1].Microseconds()) / 1000
+	}
+	c.JSON(http.StatusOK, summary)
+}
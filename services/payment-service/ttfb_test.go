@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDependencyTTFB(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		ordersFound(w, req)
+	})
+
+	empty := decodeJSON[map[string]any](t, doRequest(t, r, http.MethodGet, "/debug/dependencies/ttfb", ""))
+	if empty["samples"] != 0.0 || empty["p50_ms"] != nil {
+		t.Fatalf("summary before any call = %v, want no samples", empty)
+	}
+
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	summary := decodeJSON[struct {
+		Dependency string  `json:"dependency"`
+		Samples    int     `json:"samples"`
+		P50        float64 `json:"p50_ms"`
+		Max        float64 `json:"max_ms"`
+	}](t, doRequest(t, r, http.MethodGet, "/debug/dependencies/ttfb", ""))
+	if summary.Dependency != "order-service" || summary.Samples != 2 || summary.P50 < 20 || summary.Max < summary.P50 {
+		t.Fatalf("summary = %+v, want two samples of at least 20ms", summary)
+	}
+}
+
+// The sample buffer keeps only the most recent ttfbSampleSize samples.
+func TestTTFBSamplesAreBounded(t *testing.T) {
+	resetState()
+	t.Cleanup(resetState)
+	for i := 0; i < ttfbSampleSize+10; i++ {
+		recordOrderServiceTTFB(time.Duration(i) * time.Millisecond)
+	}
+	if len(ttfbSamples) != ttfbSampleSize {
+		t.Fatalf("%d samples kept, want %d", len(ttfbSamples), ttfbSampleSize)
+	}
+	for _, sample := range ttfbSamples {
+		if sample < 10*time.Millisecond {
+			t.Fatalf("sample %v kept, want the oldest ten replaced", sample)
+		}
+	}
+}
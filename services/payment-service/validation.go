@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// knownStatuses are all states a payment can be in.
+var knownStatuses = map[string]bool{
+	"pending":    true,
+	"processing": true,
+	"deferred":   true,
+	"completed":  true,
+	"failed":     true,
+	"cancelled":  true,
+	"expired":    true,
+	"refunded":   true,
+}
+
+// parseStatusFilter reads a comma-separated status list such as
+// "pending,processing". It returns nil when no filter was given.
+func parseStatusFilter(raw string) (map[string]bool, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	statuses := make(map[string]bool)
+	for _, status := range strings.Split(raw, ",") {
+		status = strings.TrimSpace(status)
+		if !knownStatuses[status] {
+			return nil, fmt.Errorf("unknown status %q", status)
+		}
+		statuses[status] = true
+	}
+	return statuses, nil
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePagination reads the limit and offset query parameters, which must
+// be non-negative integers; limit defaults to 50 and may not exceed 500.
+func parsePagination(rawLimit, rawOffset string) (limit, offset int, err error) {
+	limit = defaultPageLimit
+	if rawLimit != "" {
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil || limit < 0 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be an integer between 0 and %d", maxPageLimit)
+		}
+	}
+	if rawOffset != "" {
+		offset, err = strconv.Atoi(rawOffset)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
+
+// paymentIDPattern is the accepted payment ID format (PAYMENT_ID_PATTERN).
+// When nil, IDs must be canonical UUIDs as generated by POST /payments.
+var paymentIDPattern *regexp.Regexp
+
+func isValidPaymentID(paymentID string) bool {
+	if paymentIDPattern != nil {
+		return paymentIDPattern.MatchString(paymentID)
+	}
+	if len(paymentID) != 36 {
+		return false
+	}
+	_, err := uuid.Parse(paymentID)
+	return err == nil
+}
+
+// paymentIDMiddleware rejects malformed :payment_id values with 400, so
+// only well-formed IDs reach the lookup and a 404 means "no such payment".
+func paymentIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isValidPaymentID(c.Param("payment_id")) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Malformed payment ID"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// maxPaymentAmount is the largest amount a payment may have (PAYMENT_MAX_AMOUNT).
+var maxPaymentAmount = 1000000.0
+
+// validateAmount rejects amounts that are not positive finite numbers
+// within maxPaymentAmount. NaN and Inf can arrive as "NaN" or "Inf" strings.
+func validateAmount(amount float64) error {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return fmt.Errorf("amount must be a finite number")
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount must be greater than zero")
+	}
+	if amount > maxPaymentAmount {
+		return fmt.Errorf("amount %.2f exceeds the maximum of %.2f", amount, maxPaymentAmount)
+	}
+	return nil
+}
+
+var (
+	// Most metadata keys one payment may carry (PAYMENT_METADATA_MAX_KEYS)
+	maxMetadataKeys = 20
+	// Largest metadata object, in bytes of JSON (PAYMENT_METADATA_MAX_BYTES)
+	maxMetadataBytes = 2048
+)
+
+// validateMetadata bounds the free-form annotations a client attaches to a
+// payment. The limits apply to what the client sent, before sanitization.
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("metadata may have at most %d keys", maxMetadataKeys)
+	}
+	for key := range metadata {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("metadata keys must not be empty")
+		}
+	}
+	if encoded, _ := json.Marshal(metadata); len(encoded) > maxMetadataBytes {
+		return fmt.Errorf("metadata must be at most %d bytes of JSON", maxMetadataBytes)
+	}
+	return nil
+}
+
+// paymentMethods is the set of accepted payment methods (PAYMENT_METHODS).
+var paymentMethods = []string{"credit_card", "debit_card", "pix", "boleto", "paypal"}
+
+func validatePaymentMethod(method string) error {
+	for _, allowed := range paymentMethods {
+		if method == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown payment method %q; accepted: %s", method, strings.Join(paymentMethods, ", "))
+}
+
+// amountRange bounds the amounts accepted for a payment method. A zero
+// bound means that side is unlimited.
+type amountRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// methodAmountLimits maps a payment method to its accepted amount range.
+// Methods without an entry are not range-checked.
+var methodAmountLimits = map[string]amountRange{}
+
+func checkMethodAmountLimits(method string, amount float64) error {
+	limits, exists := methodAmountLimits[method]
+	if !exists {
+		return nil
+	}
+	if limits.Min > 0 && amount < limits.Min {
+		return fmt.Errorf("amount %.2f is below the minimum of %.2f for method %s", amount, limits.Min, method)
+	}
+	if limits.Max > 0 && amount > limits.Max {
+		return fmt.Errorf("amount %.2f exceeds the maximum of %.2f for method %s", amount, limits.Max, method)
+	}
+	return nil
+}
+
+var (
+	// Require payment amounts to match the order total (AMOUNT_MATCH_ORDER_TOTAL)
+	matchOrderTotal = false
+	// Differences within either tolerance still count as a match: an absolute
+	// amount (AMOUNT_TOLERANCE_ABS) or a fraction of the total (AMOUNT_TOLERANCE_REL)
+	amountToleranceAbs = 0.005
+	amountToleranceRel = 0.0
+)
+
+// amountsMatch compares a payment amount with an order total, allowing for
+// the rounding differences exact float equality would trip over.
+func amountsMatch(amount, total float64) bool {
+	diff := math.Abs(amount - total)
+	return diff <= amountToleranceAbs || diff <= amountToleranceRel*math.Abs(total)
+}
+
+// checkOrderTotal holds the amount to the cached order total when
+// matchOrderTotal is set. Orders without a known total pass.
+func checkOrderTotal(orderID string, amount float64) error {
+	if !matchOrderTotal {
+		return nil
+	}
+	info, exists := cachedOrder(orderID)
+	if exists && info.TotalAmount > 0 && !amountsMatch(amount, info.TotalAmount) {
+		return fmt.Errorf("amount %.2f does not match the order total of %.2f", amount, info.TotalAmount)
+	}
+	return nil
+}
+
+var (
+	// Maximum total amount per order per UTC day (0 disables the cap)
+	dailyOrderCap = 0.0
+	// Running totals for dailyTotalsDay, keyed by order ID
+	dailyTotals      = make(map[string]float64)
+	dailyTotalsDay   = ""
+	dailyTotalsMutex = sync.Mutex{}
+)
+
+// dailyReservation is an amount added to an order's daily total for a
+// payment that is not stored yet. It is released if the payment never is.
+type dailyReservation struct {
+	orderID string
+	day     string
+	amount  float64
+}
+
+// reserveDailyTotal adds amount to the order's total for today and reports
+// whether it stayed within dailyOrderCap. Totals reset when the day changes.
+// The reservation is nil when no cap is configured.
+func reserveDailyTotal(orderID string, amount float64) (*dailyReservation, bool) {
+	if dailyOrderCap <= 0 {
+		return nil, true
+	}
+
+	dailyTotalsMutex.Lock()
+	defer dailyTotalsMutex.Unlock()
+
+	today := clock().UTC().Format("2006-01-02")
+	if today != dailyTotalsDay {
+		dailyTotals = make(map[string]float64)
+		dailyTotalsDay = today
+	}
+	if dailyTotals[orderID]+amount > dailyOrderCap {
+		return nil, false
+	}
+	dailyTotals[orderID] += amount
+	return &dailyReservation{orderID: orderID, day: today, amount: amount}, true
+}
+
+// release gives a reserved amount back to the order's daily total. Totals
+// of a day that has already ended are left alone.
+func (r *dailyReservation) release() {
+	if r == nil {
+		return
+	}
+	dailyTotalsMutex.Lock()
+	defer dailyTotalsMutex.Unlock()
+	if r.day != dailyTotalsDay {
+		return
+	}
+	if remaining := dailyTotals[r.orderID] - r.amount; remaining > 0 {
+		dailyTotals[r.orderID] = remaining
+	} else {
+		delete(dailyTotals, r.orderID)
+	}
+}
+
+// releaseDailyTotal gives amount of a stored payment back to its order's
+// total for the day it was created, once the payment was cancelled,
+// expired, failed, refunded or deleted and no longer charges the order.
+func releaseDailyTotal(payment *Payment, amount float64) {
+	if dailyOrderCap <= 0 || amount <= 0 {
+		return
+	}
+	day := payment.CreatedAt.UTC().Format("2006-01-02")
+	(&dailyReservation{orderID: payment.OrderID, day: day, amount: amount}).release()
+}
+
+// dailyCharged is the part of a payment that still counts against its
+// order's daily total.
+func dailyCharged(payment *Payment) float64 {
+	switch payment.Status {
+	case "cancelled", "expired", "failed":
+		return 0
+	}
+	return float64(payment.Amount - payment.RefundAmount)
+}
+
+// validateCreateRequest sanitizes a create request and runs every check
+// that doesn't need the order-service, returning the 400 body on rejection.
+func validateCreateRequest(req *CreatePaymentRequest) gin.H {
+	if err := validateMetadata(req.Metadata); err != nil {
+		return gin.H{"error": err.Error()}
+	}
+	if err := sanitizeCreateRequest(req); err != nil {
+		return gin.H{"error": err.Error()}
+	}
+	if req.BatchID != "" && !isValidBatchID(req.BatchID) {
+		return gin.H{"error": "batch_id must be 1-64 letters, digits, '-' or '_'"}
+	}
+	if err := validatePaymentMethod(req.Method); err != nil {
+		return gin.H{"error": err.Error()}
+	}
+	if err := validateAmount(float64(req.Amount)); err != nil {
+		return gin.H{"error": err.Error()}
+	}
+	if err := validateCurrencyAmount(req.Currency, float64(req.Amount)); err != nil {
+		return gin.H{"error": err.Error()}
+	}
+	if err := checkMethodAmountLimits(req.Method, float64(req.Amount)); err != nil {
+		return gin.H{"error": err.Error()}
+	}
+	// Deployment-specific validators (PAYMENT_VALIDATORS)
+	if name, err := runValidators(req); err != nil {
+		return gin.H{"error": err.Error(), "validator": name}
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// unwritableStore is a file store whose every write fails.
+func unwritableStore(t *testing.T) *fileStore {
+	return &fileStore{memoryStore: newMemoryStore(), path: filepath.Join(t.TempDir(), "missing", "payments.json")}
+}
+
+func TestDailyTotalReleasedWhenPaymentIsNotStored(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &dailyOrderCap, 100.0)
+	orderID := uuid.NewString()
+
+	payments = unwritableStore(t)
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(orderID, 60, "pix"))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("create with a failing store = %d %s, want 500", w.Code, w.Body.String())
+	}
+
+	payments = newMemoryStore()
+	mustCreatePayment(t, r, orderID, 60, "pix")
+	w = doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(orderID, 60, "pix"))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("create over the cap = %d %s, want 409", w.Code, w.Body.String())
+	}
+}
+
+// A payment that stops charging its order gives its amount back to the
+// order's daily total.
+func TestDailyTotalReleasedWhenPaymentStopsCharging(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	advance := useFakeClock(t)
+	setVar(t, &dailyOrderCap, 100.0)
+	admin := useAdminToken(t)
+
+	tests := []struct {
+		name  string
+		apply func(t *testing.T, payment Payment)
+	}{
+		{"cancelled", func(t *testing.T, payment Payment) {
+			if w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/cancel", ""); w.Code != http.StatusOK {
+				t.Fatalf("cancel = %d %s, want 200", w.Code, w.Body.String())
+			}
+		}},
+		{"cancelled with the order", func(t *testing.T, payment Payment) {
+			if w := doRequest(t, r, http.MethodPost, "/orders/"+payment.OrderID+"/cancel-payments", "", admin...); w.Code != http.StatusOK {
+				t.Fatalf("cancel order payments = %d %s, want 200", w.Code, w.Body.String())
+			}
+		}},
+		{"expired", func(t *testing.T, payment Payment) {
+			advance(pendingPaymentTTL)
+			expireStalePayments()
+			if got := decodeJSON[Payment](t, doRequest(t, r, http.MethodGet, "/payments/"+payment.ID, "")); got.Status != "expired" {
+				t.Fatalf("stale payment is %s, want expired", got.Status)
+			}
+		}},
+		{"failed", func(t *testing.T, payment Payment) {
+			setVar(t, &paymentFailureThreshold, 50.0)
+			if w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/process", "", "If-Match", "*"); decodeJSON[Payment](t, w).Status != "failed" {
+				t.Fatalf("process over the failure threshold = %d %s, want failed", w.Code, w.Body.String())
+			}
+		}},
+		{"refunded", func(t *testing.T, payment Payment) {
+			mustProcessPayment(t, r, payment.ID)
+			if w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/refund", "", "If-Match", "*"); w.Code != http.StatusOK {
+				t.Fatalf("refund = %d %s, want 200", w.Code, w.Body.String())
+			}
+		}},
+		{"deleted", func(t *testing.T, payment Payment) {
+			if w := doRequest(t, r, http.MethodDelete, "/payments/"+payment.ID, "", "If-Match", "*"); w.Code != http.StatusNoContent {
+				t.Fatalf("delete = %d %s, want 204", w.Code, w.Body.String())
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orderID := uuid.NewString()
+			payment := mustCreatePayment(t, r, orderID, 60, "pix")
+			if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(orderID, 60, "pix")); w.Code != http.StatusConflict {
+				t.Fatalf("create over the cap = %d %s, want 409", w.Code, w.Body.String())
+			}
+			tt.apply(t, payment)
+			if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(orderID, 60, "pix")); w.Code != http.StatusCreated {
+				t.Fatalf("create after the payment was %s = %d %s, want its amount released", tt.name, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBulkDailyTotalReleasedWhenPaymentIsNotStored(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &dailyOrderCap, 100.0)
+	orderID := uuid.NewString()
+	body := "[" + createPaymentBody(orderID, 60, "pix") + "]"
+
+	payments = unwritableStore(t)
+	w := doRequest(t, r, http.MethodPost, "/payments/batch", body)
+	result := decodeJSON[struct {
+		Created int          `json:"created"`
+		Results []bulkResult `json:"results"`
+	}](t, w)
+	if result.Created != 0 || result.Results[0].Status != http.StatusInternalServerError {
+		t.Fatalf("bulk create with a failing store = %s, want the item to fail with 500", w.Body.String())
+	}
+
+	payments = newMemoryStore()
+	w = doRequest(t, r, http.MethodPost, "/payments/batch", body)
+	result = decodeJSON[struct {
+		Created int          `json:"created"`
+		Results []bulkResult `json:"results"`
+	}](t, w)
+	if result.Created != 1 {
+		t.Fatalf("bulk create after the failure = %s, want it created", w.Body.String())
+	}
+}
+
+func TestMethodAmountLimits(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &methodAmountLimits, map[string]amountRange{"pix": {Min: 5, Max: 100}})
+
+	tests := []struct {
+		amount float64
+		method string
+		want   int
+	}{
+		{2, "pix", http.StatusBadRequest},
+		{150, "pix", http.StatusBadRequest},
+		{50, "pix", http.StatusCreated},
+		{150, "boleto", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), tt.amount, tt.method))
+		if w.Code != tt.want {
+			t.Errorf("%s payment of %v = %d %s, want %d", tt.method, tt.amount, w.Code, w.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestMalformedPaymentIDs(t *testing.T) {
+	r := newTestRouter(t)
+	for _, target := range []string{
+		"/payments/not-a-uuid",
+		"/payments/" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		"/payments/%7B" + uuid.NewString() + "%7D",
+		"/payments/" + uuid.NewString() + "x/trace",
+	} {
+		if w := doRequest(t, r, http.MethodGet, target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, w.Code)
+		}
+	}
+	if w := doRequest(t, r, http.MethodGet, "/payments/"+uuid.NewString(), ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET an unknown well-formed ID = %d, want 404", w.Code)
+	}
+
+	setVar(t, &paymentIDPattern, regexp.MustCompile(`^pay_[0-9]{6}$`))
+	if w := doRequest(t, r, http.MethodGet, "/payments/pay_000001", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET an ID matching PAYMENT_ID_PATTERN = %d, want 404", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodGet, "/payments/"+uuid.NewString(), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("GET a UUID not matching PAYMENT_ID_PATTERN = %d, want 400", w.Code)
+	}
+}
+
+func TestAmountMatchesOrderTotalWithinTolerance(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"id":%q,"status":"pending","total_amount":100}`, path.Base(req.URL.Path))
+	})
+	setVar(t, &matchOrderTotal, true)
+	setVar(t, &amountToleranceRel, 0)
+
+	tests := []struct {
+		amount float64
+		relTol float64
+		want   int
+	}{
+		{100, 0, http.StatusCreated},
+		{100.004, 0, http.StatusCreated},
+		{100.01, 0, http.StatusBadRequest},
+		{99, 0, http.StatusBadRequest},
+		{99, 0.02, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		amountToleranceRel = tt.relTol
+		w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), tt.amount, "pix"))
+		if w.Code != tt.want {
+			t.Errorf("amount %v against a total of 100 (relative tolerance %v) = %d %s, want %d", tt.amount, tt.relTol, w.Code, w.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestAmountBounds(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &maxPaymentAmount, 5000.0)
+
+	tests := []struct {
+		name   string
+		amount string
+		want   int
+	}{
+		{"zero", `0`, http.StatusBadRequest},
+		{"negative", `-10`, http.StatusBadRequest},
+		{"over the maximum", `5000.01`, http.StatusBadRequest},
+		{"huge", `1e18`, http.StatusBadRequest},
+		{"NaN", `"NaN"`, http.StatusBadRequest},
+		{"infinity", `"Inf"`, http.StatusBadRequest},
+		{"at the maximum", `5000`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		body := fmt.Sprintf(`{"order_id":%q,"amount":%s,"method":"boleto"}`, uuid.NewString(), tt.amount)
+		w := doRequest(t, r, http.MethodPost, "/payments", body)
+		if w.Code != tt.want {
+			t.Errorf("%s amount %s = %d %s, want %d", tt.name, tt.amount, w.Code, w.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestPaymentMethodAllowlist(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+
+	for _, method := range []string{"credit_card", "debit_card", "pix", "boleto", "paypal"} {
+		mustCreatePayment(t, r, uuid.NewString(), 10, method)
+	}
+	w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "banana"))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "banana") {
+		t.Fatalf("create with an unknown method = %d %s, want 400 naming it", w.Code, w.Body.String())
+	}
+
+	setVar(t, &paymentMethods, []string{"banana"})
+	mustCreatePayment(t, r, uuid.NewString(), 10, "banana")
+	if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), 10, "pix")); w.Code != http.StatusBadRequest {
+		t.Fatalf("create with a method left out of PAYMENT_METHODS = %d, want 400", w.Code)
+	}
+}
+
+func TestIsValidOrderID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"3f2504e0-4f89-11d3-9a0c-0305e82c3301", true},
+		{"3F2504E0-4F89-11D3-9A0C-0305E82C3301", true},
+		{"3f2504e04f8911d39a0c0305e82c3301", true},
+		{"", false},
+		{"-", false},
+		{"------------------------------------", false},
+		{"deadbeef", false},
+		{"3f2504e0-4f89-11d3-9a0c-0305e82c330", false},
+		{"3f2504e04f89-11d3-9a0c-0305e82c3301-", false},
+		{"{3f2504e0-4f89-11d3-9a0c-0305e82c3301}", false},
+		{"urn:uuid:3f2504e0-4f89-11d3-9a0c-0305e82c3301", false},
+		{"3f2504e0-4f89-11d3-9a0c-0305e82c330g", false},
+		{"3f2504e04f8911d39a0c0305e82c3301ff", false},
+		{strings.Repeat("a", 51), false},
+	}
+	for _, tt := range tests {
+		if got := isValidOrderID(tt.id); got != tt.want {
+			t.Errorf("isValidOrderID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestMalformedOrderIDsNeverReachTheOrderService(t *testing.T) {
+	r := newTestRouter(t)
+	var calls atomic.Int32
+	newOrderService(t, func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		ordersFound(w, req)
+	})
+
+	for _, id := range []string{"-", "------", "deadbeef"} {
+		if w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(id, 10, "pix")); w.Code != http.StatusBadRequest {
+			t.Errorf("create for order %q = %d, want 400", id, w.Code)
+		}
+		if _, cached := cachedOrder(id); cached {
+			t.Errorf("malformed order %q was cached", id)
+		}
+	}
+	if calls.Load() != 0 {
+		t.Fatalf("order-service called %d times for malformed IDs, want none", calls.Load())
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PaymentValidator is a named check run against every payment creation
+// request. Returning an error rejects the request with that reason.
+type PaymentValidator interface {
+	Name() string
+	Validate(req *CreatePaymentRequest) error
+}
+
+var (
+	// Every validator that can be enabled, keyed by name
+	validatorRegistry = make(map[string]PaymentValidator)
+	// Validators run on POST /payments, in order (PAYMENT_VALIDATORS)
+	enabledValidators []PaymentValidator
+)
+
+// registerValidator makes a validator available to PAYMENT_VALIDATORS.
+// Registering a name twice replaces the earlier validator.
+func registerValidator(v PaymentValidator) {
+	validatorRegistry[v.Name()] = v
+}
+
+// enableValidators resolves a comma-separated list of validator names.
+// Unknown names are an error so a typo doesn't silently skip a check.
+func enableValidators(raw string) ([]PaymentValidator, error) {
+	var validators []PaymentValidator
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		v, exists := validatorRegistry[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown validator %q", name)
+		}
+		validators = append(validators, v)
+	}
+	return validators, nil
+}
+
+// runValidators applies the enabled validators in order and stops at the
+// first rejection, returning the name of the validator that rejected.
+func runValidators(req *CreatePaymentRequest) (string, error) {
+	for _, v := range enabledValidators {
+		if err := v.Validate(req); err != nil {
+			return v.Name(), err
+		}
+	}
+	return "", nil
+}
+
+// validatorNames lists the enabled validators in the order they run.
+func validatorNames() []string {
+	names := make([]string, 0, len(enabledValidators))
+	for _, v := range enabledValidators {
+		names = append(names, v.Name())
+	}
+	return names
+}
+
+func init() {
+	registerValidator(amountRangeValidator{})
+	registerValidator(methodAllowlistValidator{})
+}
+
+var (
+	// Bounds for the amount_range validator (VALIDATOR_AMOUNT_MIN/MAX)
+	validatorAmountRange = amountRange{}
+	// Methods accepted by the method_allowlist validator (VALIDATOR_ALLOWED_METHODS)
+	validatorAllowedMethods = []string{"credit_card"}
+)
+
+// amountRangeValidator rejects amounts outside validatorAmountRange,
+// whatever the payment method.
+type amountRangeValidator struct{}
+
+func (amountRangeValidator) Name() string { return "amount_range" }
+
+func (amountRangeValidator) Validate(req *CreatePaymentRequest) error {
+	amount := float64(req.Amount)
+	if validatorAmountRange.Min > 0 && amount < validatorAmountRange.Min {
+		return fmt.Errorf("amount %.2f is below the minimum of %.2f", amount, validatorAmountRange.Min)
+	}
+	if validatorAmountRange.Max > 0 && amount > validatorAmountRange.Max {
+		return fmt.Errorf("amount %.2f exceeds the maximum of %.2f", amount, validatorAmountRange.Max)
+	}
+	return nil
+}
+
+// methodAllowlistValidator rejects payment methods not in
+// validatorAllowedMethods.
+type methodAllowlistValidator struct{}
+
+func (methodAllowlistValidator) Name() string { return "method_allowlist" }
+
+func (methodAllowlistValidator) Validate(req *CreatePaymentRequest) error {
+	for _, method := range validatorAllowedMethods {
+		if req.Method == method {
+			return nil
+		}
+	}
+	return fmt.Errorf("payment method %q is not accepted", req.Method)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestEnableValidators(t *testing.T) {
+	validators, err := enableValidators(" method_allowlist, amount_range ,")
+	if err != nil || len(validators) != 2 || validators[0].Name() != "method_allowlist" || validators[1].Name() != "amount_range" {
+		t.Fatalf("enableValidators = %v, %v; want method_allowlist then amount_range", validators, err)
+	}
+	if _, err := enableValidators("amount_range,amount_ranges"); err == nil {
+		t.Fatal("enableValidators accepted an unknown validator")
+	}
+}
+
+func TestEnabledValidatorsRejectCreations(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	validators, err := enableValidators("method_allowlist,amount_range")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setVar(t, &enabledValidators, validators)
+	setVar(t, &validatorAllowedMethods, []string{"pix"})
+	setVar(t, &validatorAmountRange, amountRange{Max: 100})
+
+	tests := []struct {
+		amount    float64
+		method    string
+		validator string
+	}{
+		{10, "boleto", "method_allowlist"},
+		{500, "pix", "amount_range"},
+	}
+	for _, tt := range tests {
+		w := doRequest(t, r, http.MethodPost, "/payments", createPaymentBody(uuid.NewString(), tt.amount, tt.method))
+		rejection := decodeJSON[struct {
+			Validator string `json:"validator"`
+		}](t, w)
+		if w.Code != http.StatusBadRequest || rejection.Validator != tt.validator {
+			t.Errorf("%s payment of %v = %d %s, want 400 from %s", tt.method, tt.amount, w.Code, w.Body.String(), tt.validator)
+		}
+	}
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	eventPaymentCreated   = "payment.created"
+	eventPaymentProcessed = "payment.processed"
+	eventPaymentRefunded  = "payment.refunded"
+)
+
+var (
+	// Where payment events are POSTed; empty disables webhooks (WEBHOOK_URL)
+	webhookURL = ""
+	// Key for the X-Webhook-Signature HMAC (WEBHOOK_SECRET)
+	webhookSecret = ""
+	// How far X-Webhook-Timestamp may be from now before a signature is
+	// treated as a replay (WEBHOOK_TOLERANCE)
+	webhookTolerance = 5 * time.Minute
+	// Backoff ceiling after the first failed delivery, doubled per attempt
+	webhookRetryBaseDelay = 200 * time.Millisecond
+	// Deliveries still running, waited for on shutdown
+	webhookDeliveries sync.WaitGroup
+)
+
+var webhookClient = &http.Client{
+	Timeout: 5 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookEvent is the body of a webhook delivery.
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Payment   Payment   `json:"payment"`
+}
+
+// notifyPaymentEvent sends a payment event to the webhook in the background.
+// The payment is passed by value so callers can snapshot it under the lock
+// they already hold.
+func notifyPaymentEvent(event string, payment Payment) {
+	if webhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(webhookEvent{Event: event, Timestamp: clock(), Payment: payment})
+	if err != nil {
+		logger.Error("failed to encode webhook", "event", event, "payment_id", payment.ID, "error", err.Error())
+		return
+	}
+
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
+		deliverWebhook(event, payment.ID, body)
+	}()
+}
+
+// signWebhook returns the X-Webhook-Signature of a delivery: the hex
+// HMAC-SHA256 under webhookSecret of its X-Webhook-Timestamp, a dot and
+// the body. Signing the timestamp stops a captured delivery from being
+// replayed later with a fresh one.
+func signWebhook(timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookVerifyRequest is the body of POST /webhooks/verify: a delivery as
+// a receiver saw it.
+type WebhookVerifyRequest struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature" binding:"required"`
+	Timestamp int64  `json:"timestamp" binding:"required"`
+}
+
+// verifyWebhook reports whether a delivery's signature is valid under the
+// current secret and its timestamp within webhookTolerance, the checks a
+// receiver should make, so integrators can test their own.
+func verifyWebhook(c *gin.Context) {
+	if webhookSecret == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "WEBHOOK_SECRET is not configured"})
+		return
+	}
+	var req WebhookVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	expected := signWebhook(req.Timestamp, []byte(req.Payload))
+	if !hmac.Equal([]byte(req.Signature), []byte(expected)) {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "reason": "signature does not match"})
+		return
+	}
+	age := clock().Sub(time.Unix(req.Timestamp, 0))
+	if age > webhookTolerance || age < -webhookTolerance {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "reason": "timestamp is outside the tolerance"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": true})
+}
+
+// deliverWebhook POSTs body to webhookURL, retrying with jittered
+// exponential backoff until it gets a 2xx or runs out of attempts.
+func deliverWebhook(event, paymentID string, body []byte) {
+	var lastErr error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryDelay(attempt-1, webhookRetryBaseDelay))
+		}
+
+		req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+		if err != nil {
+			lastErr = err
+			break
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Webhook-Event", event)
+		// Signed per attempt so a retry isn't rejected as stale
+		timestamp := clock().Unix()
+		req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+		req.Header.Set("X-Webhook-Signature", signWebhook(timestamp, body))
+
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		discardBody(resp)
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return
+		}
+		lastErr = fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	logger.Warn("webhook delivery failed",
+		"event", event, "payment_id", paymentID, "error", lastErr.Error())
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// delivery is one webhook request as received by the consumer.
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookConsumer points webhookURL at a server that answers with the
+// given statuses in turn (200 once they run out) and records every request.
+func newWebhookConsumer(t *testing.T, statuses ...int) <-chan delivery {
+	t.Helper()
+	received := make(chan delivery, 16)
+	var calls atomic.Int64
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- delivery{header: r.Header.Clone(), body: body}
+		if n := int(calls.Add(1)); n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	t.Cleanup(func() {
+		webhookDeliveries.Wait()
+		webhookClient.CloseIdleConnections()
+		consumer.Close()
+	})
+	setVar(t, &webhookURL, consumer.URL+"/hooks")
+	return received
+}
+
+func TestWebhooksFireOnPaymentEvents(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &webhookSecret, "s3cret")
+	received := newWebhookConsumer(t)
+
+	payment := mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	mustProcessPayment(t, r, payment.ID)
+	if w := doRequest(t, r, http.MethodPost, "/payments/"+payment.ID+"/refund", "", "If-Match", "*"); w.Code != http.StatusOK {
+		t.Fatalf("refund = %d %s, want 200", w.Code, w.Body.String())
+	}
+	webhookDeliveries.Wait()
+
+	want := map[string]string{
+		eventPaymentCreated:   "pending",
+		eventPaymentProcessed: "completed",
+		eventPaymentRefunded:  "refunded",
+	}
+	for events := len(want); events > 0; events-- {
+		d := <-received
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(d.header.Get("X-Webhook-Timestamp") + "."))
+		mac.Write(d.body)
+		if got, want := d.header.Get("X-Webhook-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+
+		var event webhookEvent
+		if err := json.Unmarshal(d.body, &event); err != nil {
+			t.Fatalf("webhook body %s: %v", d.body, err)
+		}
+		status, ok := want[event.Event]
+		if !ok {
+			t.Fatalf("unexpected or repeated event %q", event.Event)
+		}
+		delete(want, event.Event)
+		if d.header.Get("X-Webhook-Event") != event.Event || event.Payment.ID != payment.ID || event.Payment.Status != status {
+			t.Errorf("%s webhook = %s (header %q), want payment %s %s", event.Event, d.body, d.header.Get("X-Webhook-Event"), payment.ID, status)
+		}
+	}
+	if len(received) != 0 {
+		t.Errorf("%d extra webhook deliveries", len(received))
+	}
+}
+
+func TestWebhookDeliveryIsRetried(t *testing.T) {
+	r := newTestRouter(t)
+	newOrderService(t, ordersFound)
+	setVar(t, &webhookRetryBaseDelay, time.Millisecond)
+	received := newWebhookConsumer(t, http.StatusServiceUnavailable)
+
+	mustCreatePayment(t, r, uuid.NewString(), 10, "pix")
+	webhookDeliveries.Wait()
+	if len(received) != 2 {
+		t.Fatalf("deliveries = %d, want a failed attempt and a successful retry", len(received))
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	r := newTestRouter(t)
+	admin := useAdminToken(t)
+	advance := useFakeClock(t)
+	setVar(t, &webhookSecret, "s3cret")
+
+	payload := `{"event":"payment.created"}`
+	timestamp := clock().Unix()
+	verify := func(payload, signature string, timestamp int64) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(WebhookVerifyRequest{Payload: payload, Signature: signature, Timestamp: timestamp})
+		return doRequest(t, r, http.MethodPost, "/webhooks/verify", string(body), admin...)
+	}
+	type verdict struct {
+		Valid  bool   `json:"valid"`
+		Reason string `json:"reason"`
+	}
+
+	signature := signWebhook(timestamp, []byte(payload))
+	forged := signature[:len(signature)-1] + "0"
+	if forged == signature {
+		forged = signature[:len(signature)-1] + "1"
+	}
+	if w := verify(payload, signature, timestamp); w.Code != http.StatusOK || !decodeJSON[verdict](t, w).Valid {
+		t.Fatalf("verify a signed payload = %d %s, want valid", w.Code, w.Body.String())
+	}
+	tampered := map[string]*httptest.ResponseRecorder{
+		"payload":   verify(`{"event":"payment.refunded"}`, signature, timestamp),
+		"timestamp": verify(payload, signature, timestamp+1),
+		"signature": verify(payload, forged, timestamp),
+	}
+	for field, w := range tampered {
+		if got := decodeJSON[verdict](t, w); w.Code != http.StatusOK || got.Valid || got.Reason == "" {
+			t.Errorf("verify with a tampered %s = %d %s, want invalid with a reason", field, w.Code, w.Body.String())
+		}
+	}
+
+	advance(webhookTolerance + time.Second)
+	if w := verify(payload, signature, timestamp); w.Code != http.StatusOK || decodeJSON[verdict](t, w).Valid {
+		t.Fatalf("verify a delivery older than the tolerance = %d %s, want it rejected as a replay", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodPost, "/webhooks/verify", `{"payload":"{}"}`, admin...); w.Code != http.StatusBadRequest {
+		t.Fatalf("verify without a signature = %d, want 400", w.Code)
+	}
+	if w := doRequest(t, r, http.MethodPost, "/webhooks/verify", `{}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("verify without the admin token = %d, want 401", w.Code)
+	}
+}
@@ -138,6 +138,7 @@ class TestEndToEndFlow:
         # 4. Process Payment
         process_response = requests.post(
             f"{self.BASE_URLS['payment']}/payments/{payment_id}/process",
+            headers={'If-Match': str(payment['version'])},
             timeout=DEFAULT_TIMEOUT
         )
         assert process_response.status_code == 200
@@ -262,7 +263,8 @@ class TestEndToEndFlow:
         payment_id = payment_data['id']
         
         process_response = requests.post(
-            f"{self.BASE_URLS['payment']}/payments/{payment_id}/process"
+            f"{self.BASE_URLS['payment']}/payments/{payment_id}/process",
+            headers={'If-Match': str(payment_data['version'])}
         )
         processed_payment = process_response.json()
         assert processed_payment['status'] == 'failed'
\ No newline at end of file
@@ -83,6 +83,7 @@ class TestErrorScenarios:
                     if payment_id:
                         process_response = requests.post(
                             f"{self.BASE_URLS['payment']}/payments/{payment_id}/process",
+                            headers={'If-Match': str(response.json()['version'])},
                             timeout=5
                         )
                         if process_response.status_code == 200:
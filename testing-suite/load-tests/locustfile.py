@@ -248,7 +248,7 @@ class PaymentServiceLoadTest(HttpUser):
         if self.created_payments:
             payment_id = random.choice(self.created_payments)
             
-            headers = {}
+            headers = {'If-Match': '*'}
             if self.csrf_token:
                 headers['X-CSRF-Token'] = self.csrf_token
             